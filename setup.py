@@ -1,14 +1,26 @@
+import re
 
 from setuptools import setup, find_packages
 
+with open("src/perplexity_api/version.py", encoding="utf-8") as f:
+    version = re.search(r'__version__ = "([^"]+)"', f.read()).group(1)
+
 setup(
     name="perplexity-api",
-    version="0.1.0",
+    version=version,
     packages=find_packages(where="src"),
     package_dir={"": "src"},
-    python_requires=">=3.6",
+    python_requires=">=3.7",
     install_requires=[
         "requests>=2.26.0",
-        "perplexipy>=1.1.3"
+        "python-dotenv>=1.0.0",
+        "cryptography>=41.0.0"
     ],
+    extras_require={
+        "color": ["pygments>=2.0"],
+        "tracing": ["opentelemetry-api>=1.0"]
+    },
+    entry_points={
+        "console_scripts": ["pplx=perplexity_api.main:main"],
+    },
 )
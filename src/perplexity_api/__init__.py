@@ -0,0 +1,71 @@
+from .cache import ResponseCache
+from .client import (DEFAULT_SYSTEM_PROMPT, BatchResult, Handler, Middleware, PerplexityAPI,
+                     PerplexityConfig, RateLimitInfo, SessionStats, Usage, finish_reason,
+                     is_truncated)
+from .config import load_config
+from .content import image_part, message_content
+from .conversation import (ROLE_ASSISTANT, ROLE_SYSTEM, ROLE_USER, export_openai, import_openai,
+                           load_conversation, save_conversation, trim_history,
+                           validate_messages)
+from .exceptions import (APIError, AuthenticationError, CacheMissError, NetworkError, NoChoicesError,
+                         RateLimitError, RequestCancelled, StreamInterruptedError)
+from .export import to_markdown
+from .metrics import Metrics
+from .models import MODELS, ModelInfo, estimate_cost, get_model, list_models
+from .ratelimit import AdaptiveRateLimiter, RateLimiter
+from .sanitize import sanitize_input
+from .structured import json_schema_format, schema_from_dataclass
+from .template import render_prompt
+from .tokens import estimate_tokens, split_text
+from .version import __version__
+
+__all__ = [
+    "__version__",
+    "APIError",
+    "AdaptiveRateLimiter",
+    "AuthenticationError",
+    "BatchResult",
+    "CacheMissError",
+    "DEFAULT_SYSTEM_PROMPT",
+    "Handler",
+    "MODELS",
+    "Metrics",
+    "Middleware",
+    "ModelInfo",
+    "NetworkError",
+    "NoChoicesError",
+    "PerplexityAPI",
+    "PerplexityConfig",
+    "ROLE_ASSISTANT",
+    "ROLE_SYSTEM",
+    "ROLE_USER",
+    "RateLimitError",
+    "RateLimitInfo",
+    "RateLimiter",
+    "RequestCancelled",
+    "ResponseCache",
+    "SessionStats",
+    "StreamInterruptedError",
+    "Usage",
+    "estimate_cost",
+    "estimate_tokens",
+    "export_openai",
+    "finish_reason",
+    "get_model",
+    "image_part",
+    "import_openai",
+    "is_truncated",
+    "json_schema_format",
+    "list_models",
+    "load_config",
+    "load_conversation",
+    "message_content",
+    "render_prompt",
+    "sanitize_input",
+    "save_conversation",
+    "schema_from_dataclass",
+    "split_text",
+    "to_markdown",
+    "trim_history",
+    "validate_messages",
+]
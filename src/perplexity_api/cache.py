@@ -0,0 +1,82 @@
+import hashlib
+import json
+import os
+import tempfile
+import time
+from typing import Dict, Optional
+
+class ResponseCache:
+    """
+    On-disk cache of API responses keyed by a hash of the request payload.
+
+    Attributes:
+        directory (str): Directory holding one JSON file per cached response
+        ttl (float): Seconds a cached response stays valid
+    """
+    def __init__(self, directory: str, ttl: float = 3600):
+        """
+        Initialize the cache, creating its directory if needed.
+
+        Args:
+            directory (str): Where cached responses are stored
+            ttl (float): Seconds before a cached response expires
+        """
+        if ttl <= 0:
+            raise ValueError("ttl must be positive")
+        self.directory = os.path.expanduser(directory)
+        self.ttl = ttl
+        os.makedirs(self.directory, exist_ok=True)
+
+    @staticmethod
+    def key_for(payload: Dict) -> str:
+        """Return a stable key for a request payload."""
+        canonical = json.dumps(payload, sort_keys=True, separators=(",", ":"))
+        return hashlib.sha256(canonical.encode("utf-8")).hexdigest()
+
+    def _path(self, key: str) -> str:
+        return os.path.join(self.directory, f"{key}.json")
+
+    def get(self, key: str) -> Optional[Dict]:
+        """
+        Return the cached response for a key.
+
+        Args:
+            key (str): Key from key_for
+
+        Returns:
+            Optional[Dict]: The response, or None if missing, expired or unreadable
+        """
+        path = self._path(key)
+        try:
+            with open(path, encoding="utf-8") as f:
+                entry = json.load(f)
+        except (OSError, ValueError):
+            return None
+        if time.time() - entry.get("created", 0) > self.ttl:
+            try:
+                os.remove(path)
+            except OSError:
+                pass
+            return None
+        return entry.get("response")
+
+    def set(self, key: str, response: Dict) -> None:
+        """
+        Store a response under a key.
+
+        Args:
+            key (str): Key from key_for
+            response (Dict): Parsed API response
+        """
+        # Write to a temporary file first so readers never see a partial entry
+        fd, tmp_path = tempfile.mkstemp(dir=self.directory, suffix=".tmp")
+        try:
+            with os.fdopen(fd, "w", encoding="utf-8") as f:
+                json.dump({"created": time.time(), "response": response}, f)
+            os.replace(tmp_path, self._path(key))
+        except BaseException:
+            try:
+                os.remove(tmp_path)
+            except OSError:
+                pass
+            raise
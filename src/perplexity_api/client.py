@@ -0,0 +1,1724 @@
+import contextvars
+import gzip
+import os
+import json
+import logging
+import random
+import threading
+import time
+import requests
+from typing import Callable, Dict, List, Optional, TextIO, Tuple, Union, Generator
+from dotenv import load_dotenv
+from concurrent.futures import ThreadPoolExecutor, as_completed
+from contextlib import contextmanager
+from dataclasses import dataclass, fields, replace
+from datetime import datetime, timezone
+from email.utils import parsedate_to_datetime
+
+from .cache import ResponseCache
+from .conversation import validate_messages
+from .exceptions import (APIError, AuthenticationError, CacheMissError, NetworkError, NoChoicesError,
+                         RateLimitError, RequestCancelled, StreamInterruptedError)
+from .keys import KeyPool
+from .metrics import Metrics
+from .models import MODELS, ModelInfo, estimate_cost, get_model
+from .ratelimit import AdaptiveRateLimiter, RateLimiter
+from .singleflight import SingleFlight
+from .tokens import estimate_tokens, split_text
+from .tracing import default_tracer, request_span
+from .version import __version__
+
+logger = logging.getLogger(__name__)
+
+# Sends a prepared request and returns its response
+Handler = Callable[[requests.PreparedRequest], requests.Response]
+
+# Takes the next handler in the chain and returns one that wraps it
+Middleware = Callable[[Handler], Handler]
+
+DEFAULT_BASE_URL = "https://api.perplexity.ai/chat/completions"
+
+# Identifies this client in the API's logs unless a user_agent is given
+DEFAULT_USER_AGENT = f"perplexity-api-python/{__version__}"
+
+# System instructions the single-prompt helpers and the CLI send unless told otherwise
+DEFAULT_SYSTEM_PROMPT = "Be precise and concise."
+
+# PerplexityConfig fields that can be overridden per call
+REQUEST_PARAMS = (
+    "model", "temperature", "top_p", "max_tokens", "presence_penalty",
+    "frequency_penalty", "stop", "n", "search_domain_filter", "return_images",
+    "return_related_questions", "search_recency_filter", "top_k", "response_format"
+)
+
+# Values accepted by search_recency_filter
+SEARCH_RECENCY_FILTERS = ("hour", "day", "week", "month", "year")
+
+# First bytes of a gzip stream
+GZIP_MAGIC = b"\x1f\x8b"
+
+# Characters of a non-JSON error body kept in APIError messages
+ERROR_SNIPPET_LENGTH = 200
+
+# Top-level stream chunk fields carried over into the response assembled by stream_to
+STREAM_METADATA_KEYS = ("id", "model", "created", "usage", "citations", "images",
+                        "related_questions")
+
+# finish_reason of a choice cut off by max_tokens
+FINISH_REASON_LENGTH = "length"
+
+# Seconds a rate-limited key rests when the API doesn't send Retry-After
+KEY_COOLDOWN = 60
+
+# Seconds between checks of a cancel event while a request is in flight
+CANCEL_POLL_INTERVAL = 0.05
+
+# Status codes that indicate a transient failure worth retrying
+RETRYABLE_STATUS_CODES = {429, 500, 502, 503, 504}
+
+# Instructions for summarize(): one chunk of the text, then the partial summaries
+SUMMARIZE_PROMPT = ("Summarize the text the user sends. Keep every key point, name and figure; "
+                    "do not add information that isn't in the text.")
+COMBINE_SUMMARIES_PROMPT = ("The user sends summaries of consecutive parts of one document. "
+                            "Combine them into a single coherent summary of the whole document.")
+
+# Tokens summarize() reserves for each summary when max_tokens isn't set, and for its instructions
+SUMMARY_ANSWER_TOKENS = 1024
+SUMMARY_OVERHEAD_TOKENS = 256
+
+@dataclass
+class PerplexityConfig:
+    """
+    Configuration class for Perplexity API settings.
+
+    Attributes:
+        api_key (str): The API key for authentication
+        base_url (str): The base URL for the API
+        model (str): The AI model to use (default: sonar)
+        temperature (Optional[float]): Controls randomness in responses (0.0 to 1.0);
+                                       None leaves it to the API default
+        top_p (Optional[float]): Nucleus sampling threshold (0.0 to 1.0)
+        top_k (Optional[int]): Number of highest-probability tokens to sample from (0 disables)
+        max_tokens (Optional[int]): Maximum tokens in response
+        presence_penalty (Optional[float]): Penalty for new topic introduction (-2.0 to 2.0)
+        frequency_penalty (Optional[float]): Penalty for repetition (-2.0 to 2.0)
+        stop (Optional[list]): Strings at which generation halts
+        n (Optional[int]): Number of alternative completions to generate
+        search_domain_filter (Optional[list]): Domains to search, e.g. ["wikipedia.org"];
+                                               prefix with "-" to exclude, e.g. "-reddit.com"
+        search_recency_filter (Optional[str]): Only search sources from the last
+                                               hour, day, week, month or year
+        return_images (bool): Ask online models to include related images
+        return_related_questions (bool): Ask for suggested follow-up questions
+        response_format (Optional[dict]): Structured output request, e.g. from
+                                          json_schema_format(); None for free text
+        max_retries (int): Retries for network errors, 429 and 5xx responses (0 disables)
+        retry_base_delay (float): Delay in seconds before the first retry; doubles each attempt
+        rate_limit (float): Maximum requests per second sent by the client; 0 turns
+                            off rate limiting entirely, including per-model limits
+        model_rate_limits (Optional[dict]): Requests per second allowed per model, by
+                                            name; overrides the registry's limits,
+                                            which apply on top of rate_limit, and
+                                            allows no burst. 0 turns off the limit
+                                            for that model.
+        adaptive_rate_limit (bool): Slow down on each 429 and speed back up after a
+                                    clean period, instead of using fixed rates
+        min_rate_limit (float): Lowest rate, per second, adaptive limiting backs off to
+        max_rate_limit (Optional[float]): Highest client-wide rate adaptive limiting
+                                          recovers to; defaults to rate_limit
+        timeout (float): Seconds allowed for a non-streaming call, covering the
+                         whole request/response cycle
+        stream_timeout (float): Seconds a streaming response may go without sending
+                                data; streams have no limit on their total duration
+        check_context_window (bool): Refuse to send requests whose estimated size
+                                     exceeds the model's context window
+        deduplicate_requests (bool): Let identical non-streaming calls made at the
+                                     same time share one HTTP request and its
+                                     response (or error); streams are never shared
+        pool_connections (int): Hosts to keep connection pools for; the API is one host
+        pool_maxsize (int): Idle connections kept open per host for reuse; raise it
+                            above the number of concurrent requests to avoid opening
+                            (and exhausting ports on) new ones. Applies only when the
+                            client creates its own session.
+        fallback_models (Optional[list]): Models chat() tries in turn when the
+                                          requested one keeps failing with a network
+                                          error, 429 or 5xx
+    """
+    api_key: str
+    base_url: str = DEFAULT_BASE_URL
+    model: str = "sonar"
+    temperature: Optional[float] = 0.2
+    top_p: Optional[float] = 0.9
+    max_tokens: Optional[int] = None
+    presence_penalty: Optional[float] = 0
+    frequency_penalty: Optional[float] = 1
+    stop: Optional[list] = None
+    n: Optional[int] = None
+    search_domain_filter: Optional[list] = None
+    return_images: bool = False
+    return_related_questions: bool = False
+    search_recency_filter: Optional[str] = "month"
+    top_k: Optional[int] = 0
+    response_format: Optional[dict] = None
+    max_retries: int = 3
+    retry_base_delay: float = 0.2
+    rate_limit: float = 10.0
+    model_rate_limits: Optional[dict] = None
+    adaptive_rate_limit: bool = False
+    min_rate_limit: float = 0.1
+    max_rate_limit: Optional[float] = None
+    timeout: float = 30
+    stream_timeout: float = 60
+    check_context_window: bool = False
+    deduplicate_requests: bool = False
+    pool_connections: int = 1
+    pool_maxsize: int = 16
+    fallback_models: Optional[list] = None
+
+@dataclass
+class Usage:
+    """
+    Token usage reported by the API for a single completion.
+
+    Attributes:
+        prompt_tokens (int): Tokens consumed by the input messages
+        completion_tokens (int): Tokens generated in the response
+        total_tokens (int): Sum of prompt and completion tokens
+    """
+    prompt_tokens: int = 0
+    completion_tokens: int = 0
+    total_tokens: int = 0
+
+    @classmethod
+    def from_response(cls, response: Dict) -> Optional["Usage"]:
+        """
+        Extract usage from an API response.
+
+        Args:
+            response (Dict): Parsed API response or final stream chunk
+
+        Returns:
+            Optional[Usage]: The reported usage, or None if the response has none
+        """
+        usage = response.get("usage")
+        if not usage:
+            return None
+        return cls(
+            prompt_tokens=usage.get("prompt_tokens", 0),
+            completion_tokens=usage.get("completion_tokens", 0),
+            total_tokens=usage.get("total_tokens", 0)
+        )
+
+@dataclass
+class SessionStats:
+    """
+    Running totals for every request a client has sent.
+
+    Attributes:
+        requests (int): Completions received from the API; cache hits aren't counted
+        prompt_tokens (int): Prompt tokens across those completions
+        completion_tokens (int): Completion tokens across those completions
+        total_tokens (int): Sum of prompt and completion tokens
+        cost (float): Estimated cost in USD, for models with known pricing
+    """
+    requests: int = 0
+    prompt_tokens: int = 0
+    completion_tokens: int = 0
+    total_tokens: int = 0
+    cost: float = 0.0
+
+@dataclass
+class RateLimitInfo:
+    """
+    Quota reported by the API's X-RateLimit-* headers on the latest response that had them.
+
+    Attributes:
+        model (Optional[str]): Model the response was for; limits apply per model
+        limit (Optional[int]): Requests allowed in the current window
+        remaining (Optional[int]): Requests left in the current window
+        reset_at (Optional[float]): Unix time at which the window resets
+        updated_at (float): Unix time the headers were received
+    """
+    model: Optional[str] = None
+    limit: Optional[int] = None
+    remaining: Optional[int] = None
+    reset_at: Optional[float] = None
+    updated_at: float = 0.0
+
+    def reset_in(self) -> Optional[float]:
+        """Return the seconds until the window resets, or None if unknown."""
+        if self.reset_at is None:
+            return None
+        return max(0.0, self.reset_at - time.time())
+
+@dataclass
+class BatchResult:
+    """
+    Outcome of one request in a batch.
+
+    Attributes:
+        response (Optional[Dict]): API response, or None if the request failed
+        error (Optional[Exception]): Why the request failed, or None on success
+        duration (Optional[float]): Wall-clock seconds the request took, including
+                                    any rate limit waits and retries
+        latency (Optional[float]): Seconds from sending the last HTTP attempt until
+                                   its response was read, i.e. the API's share of
+                                   duration; None if nothing was sent, e.g. a cache hit
+        queue_time (float): Seconds spent waiting on rate limiters and between retries
+    """
+    response: Optional[Dict] = None
+    error: Optional[Exception] = None
+    duration: Optional[float] = None
+    latency: Optional[float] = None
+    queue_time: float = 0.0
+
+@dataclass
+class _CallTiming:
+    """Where one call's time went, filled in by _post while a batch collects it."""
+    queued: float = 0.0
+    sent_at: Optional[float] = None
+
+# Timing of the call running in the current context, when a batch is measuring it
+_call_timing = contextvars.ContextVar("perplexity_call_timing", default=None)
+
+class PerplexityAPI:
+    """
+    Main class for interacting with the Perplexity API.
+    """
+    def __init__(self, api_key: Optional[Union[str, List[str]]] = None, rate_limit: float = 10.0,
+                 session: Optional[requests.Session] = None, timeout: float = 30,
+                 proxy: Optional[str] = None, base_url: Optional[str] = None,
+                 sanitizer: Optional[Callable[[str], str]] = None, debug: bool = False,
+                 cache_dir: Optional[str] = None, cache_ttl: float = 3600,
+                 headers: Optional[Dict[str, str]] = None, user_agent: Optional[str] = None,
+                 middleware: Optional[List[Middleware]] = None, tracer=None,
+                 metrics: Optional[Metrics] = None, ca_bundle: Optional[str] = None,
+                 offline: bool = False, **options):
+        """
+        Initialize the Perplexity API client.
+
+        Args:
+            api_key (Optional[Union[str, List[str]]]): API key for authentication, or a
+                                   list of keys to switch between when one is rate
+                                   limited or rejected. If not provided, PPLX_API_KEY
+                                   is read from the environment, then from a .env
+                                   file; a missing .env is not an error.
+            rate_limit (float): Maximum requests per second this client will send;
+                                0 disables rate limiting, e.g. for a single
+                                latency-sensitive call
+            session (Optional[requests.Session]): HTTP session to send requests with,
+                                                  e.g. one with custom adapters or
+                                                  proxies. A new session is created
+                                                  if not provided.
+            timeout (float): Default seconds allowed for a non-streaming call
+            proxy (Optional[str]): Proxy URL for all requests, e.g. "http://proxy:3128";
+                                   takes precedence over HTTPS_PROXY. Without it,
+                                   HTTPS_PROXY/HTTP_PROXY/NO_PROXY from the
+                                   environment are honoured.
+            base_url (Optional[str]): Chat completions endpoint, e.g. a local stub.
+                                      Falls back to PPLX_API_URL, then the public API.
+            sanitizer (Optional[Callable[[str], str]]): Applied to the content of every
+                                                        user message before sending, e.g.
+                                                        sanitize_input. Messages are sent
+                                                        verbatim when not provided.
+            debug (bool): Log each outgoing payload and raw response body at DEBUG
+                          level, with the Authorization header redacted
+            cache_dir (Optional[str]): Directory for caching non-streaming responses;
+                                       identical requests are then served from disk
+            cache_ttl (float): Seconds a cached response stays valid
+            headers (Optional[Dict[str, str]]): Extra headers sent with every request,
+                                                e.g. a gateway's subscription key. They
+                                                win over the client's own headers; a
+                                                custom Authorization logs a warning.
+            user_agent (Optional[str]): User-Agent header; defaults to
+                                        perplexity-api-python/<version>
+            middleware (Optional[List[Middleware]]): Wrappers around each HTTP attempt,
+                                                     e.g. for logging or metrics. The
+                                                     first one listed is outermost: it
+                                                     sees the request first and the
+                                                     response last. Retries happen
+                                                     outside the chain, so every attempt
+                                                     passes through it.
+            tracer: OpenTelemetry tracer to record a span for each call with; defaults
+                    to the global tracer when OpenTelemetry is installed, otherwise
+                    calls aren't traced
+            metrics (Optional[Metrics]): Receives request, latency, retry and error
+                                         observations labelled by model; by
+                                         default they are discarded
+            ca_bundle (Optional[str]): PEM file (or directory of certificates) to
+                                       verify the API's TLS certificate against
+                                       instead of the system roots, e.g. for a
+                                       TLS-inspecting proxy with a private CA.
+                                       Without it, REQUESTS_CA_BUNDLE is honoured.
+            offline (bool): Serve every call from the cache and raise CacheMissError
+                            instead of calling the API, e.g. for reproducible demos
+                            and tests. Needs cache_dir; no API key is required.
+            **options: Any other PerplexityConfig field, e.g. model="..." or
+                       max_retries=5, applied before the client is set up
+
+        Raises:
+            TypeError: If an option is not a PerplexityConfig field
+            ValueError: If no API key is found, or offline is set without cache_dir
+        """
+        known = {field.name for field in fields(PerplexityConfig)}
+        for name in options:
+            if name not in known:
+                raise TypeError(f"Unknown option: {name}")
+
+        load_dotenv()
+        if api_key is None or isinstance(api_key, str):
+            api_key = [api_key or os.getenv("PPLX_API_KEY")]
+        keys = [key for key in api_key if key]
+        self.config = PerplexityConfig(
+            api_key=keys[0] if keys else None,
+            base_url=base_url or os.getenv("PPLX_API_URL") or DEFAULT_BASE_URL,
+            rate_limit=rate_limit,
+            timeout=timeout,
+            **options
+        )
+        if offline and not cache_dir:
+            raise ValueError("offline mode needs cache_dir to serve responses from")
+        if not self.config.api_key and not offline:
+            raise ValueError("API key not found. Set PPLX_API_KEY environment variable or pass it directly.")
+        self.offline = offline
+
+        # Keys are encrypted in memory; offline, a missing key is never sent anywhere
+        self._keys = KeyPool(keys or [""])
+
+        # Reuse one HTTP session so connections are pooled across calls
+        if session is None:
+            session = requests.Session()
+            adapter = requests.adapters.HTTPAdapter(pool_connections=self.config.pool_connections,
+                                                    pool_maxsize=self.config.pool_maxsize)
+            session.mount("https://", adapter)
+            session.mount("http://", adapter)
+        self.session = session
+        # Passed per request rather than set on the session, which may be the caller's
+        self.proxies = {"http": proxy, "https": proxy} if proxy else {}
+        if ca_bundle and not os.path.exists(ca_bundle):
+            raise ValueError(f"CA bundle not found: {ca_bundle}")
+        self.ca_bundle = ca_bundle
+        # None means requests are never throttled
+        self.rate_limiter: Optional[RateLimiter] = None
+        self.model_rate_limiters: Dict[str, RateLimiter] = {}
+        if self.config.rate_limit:
+            self.rate_limiter = self._make_rate_limiter(self.config.rate_limit,
+                                                        self.config.max_rate_limit)
+            # One limiter per model, so a strict model doesn't slow requests to the others.
+            # The registry's limits are published per minute, so a minute's worth may go
+            # out back to back; overrides are plain per-second rates like rate_limit.
+            model_limits = {model.name: (model.rate_limit, max(1, round(model.rate_limit * 60)))
+                            for model in MODELS if model.rate_limit}
+            model_limits.update({name: (rate, 1)
+                                 for name, rate in (self.config.model_rate_limits or {}).items()})
+            self.model_rate_limiters = {
+                name: self._make_rate_limiter(rate, burst=burst)
+                for name, (rate, burst) in model_limits.items() if rate
+            }
+        self.sanitizer = sanitizer
+        self.debug = debug
+        self.cache = ResponseCache(cache_dir, cache_ttl) if cache_dir else None
+        self.headers = dict(headers or {})
+        self.user_agent = user_agent or DEFAULT_USER_AGENT
+        self.middleware = list(middleware or [])
+        self.tracer = tracer if tracer is not None else default_tracer()
+        self.metrics = metrics or Metrics()
+        self._in_flight = SingleFlight()
+        self._stats = SessionStats()
+        self._rate_limit_info: Optional[RateLimitInfo] = None
+        self._stats_lock = threading.Lock()
+        if any(name.lower() == "authorization" for name in self.headers):
+            logger.warning("Custom Authorization header replaces the API key on every request")
+
+    def _make_rate_limiter(self, rate: float, max_rate: Optional[float] = None,
+                           burst: int = 1) -> RateLimiter:
+        """Create a fixed or adaptive limiter according to the config."""
+        if not self.config.adaptive_rate_limit:
+            return RateLimiter(rate, burst)
+        return AdaptiveRateLimiter(rate, min(self.config.min_rate_limit, rate), max_rate, burst)
+
+    def session_stats(self) -> SessionStats:
+        """
+        Return the usage and estimated cost of everything this client has sent so far.
+
+        Returns:
+            SessionStats: A snapshot of the totals
+        """
+        with self._stats_lock:
+            return replace(self._stats)
+
+    def rate_limit_status(self) -> Optional[RateLimitInfo]:
+        """
+        Return the quota the API reported most recently.
+
+        Returns:
+            Optional[RateLimitInfo]: The latest X-RateLimit-* values, or None if no
+                                     response has carried them yet
+        """
+        return self._rate_limit_info
+
+    def _record_usage(self, model: str, response: Dict) -> None:
+        """Add one completion's usage to the session totals."""
+        usage = Usage.from_response(response)
+        with self._stats_lock:
+            self._stats.requests += 1
+            if usage is None:
+                return
+            self._stats.prompt_tokens += usage.prompt_tokens
+            self._stats.completion_tokens += usage.completion_tokens
+            self._stats.total_tokens += usage.total_tokens
+            if get_model(model):
+                self._stats.cost += estimate_cost(usage, model)
+
+    def _get_headers(self, api_key: str) -> Dict[str, str]:
+        """Generate headers for API requests including authentication and any custom headers."""
+        headers = {
+            "Accept": "application/json",
+            "Content-Type": "application/json",
+            "Authorization": f"Bearer {api_key}",
+            "User-Agent": self.user_agent
+        }
+        # Match case-insensitively so a custom header replaces ours instead of duplicating it
+        for name, value in self.headers.items():
+            for existing in [key for key in headers if key.lower() == name.lower()]:
+                del headers[existing]
+            headers[name] = value
+        return headers
+
+    def _build_payload(self, messages: List[Dict[str, str]], stream: bool,
+                       params: Optional[Dict] = None) -> Dict:
+        """
+        Build the request body from the configured parameters.
+
+        Args:
+            messages (List[Dict[str, str]]): Messages to send to the model
+            stream (bool): Whether to request a streaming response
+            params (Optional[Dict]): Per-call overrides of PerplexityConfig request
+                                     parameters; None values fall back to the config
+
+        Returns:
+            Dict: JSON-serialisable request payload
+        """
+        options = {name: getattr(self.config, name) for name in REQUEST_PARAMS}
+        for name, value in (params or {}).items():
+            if name not in options:
+                raise TypeError(f"Unknown request parameter: {name}")
+            if value is not None:
+                options[name] = value
+        _validate_params(options)
+        validate_messages(messages)
+        info = get_model(options["model"])
+        if info is not None and info.deprecated:
+            _warn_deprecated(info)
+
+        if self.sanitizer:
+            messages = [
+                dict(message, content=self._sanitize_content(message["content"]))
+                if message.get("role") == "user" else message
+                for message in messages
+            ]
+
+        if self.config.check_context_window:
+            _check_context_window(messages, options["model"], options["max_tokens"])
+
+        payload = {
+            "model": options["model"],
+            "messages": messages,
+            "stream": stream
+        }
+
+        # Add optional parameters if set; 0 is a meaningful temperature
+        if options["temperature"] is not None:
+            payload["temperature"] = options["temperature"]
+        if options["top_p"] is not None:
+            payload["top_p"] = options["top_p"]
+        if options["top_k"] is not None:
+            payload["top_k"] = options["top_k"]
+        if options["max_tokens"] is not None:
+            payload["max_tokens"] = options["max_tokens"]
+        if options["presence_penalty"] is not None:
+            payload["presence_penalty"] = options["presence_penalty"]
+        if options["frequency_penalty"] is not None:
+            payload["frequency_penalty"] = options["frequency_penalty"]
+        if options["stop"]:
+            payload["stop"] = options["stop"]
+        if options["n"] is not None:
+            payload["n"] = options["n"]
+        if options["search_domain_filter"]:
+            payload["search_domain_filter"] = list(options["search_domain_filter"])
+        if options["search_recency_filter"]:
+            payload["search_recency_filter"] = options["search_recency_filter"]
+        if options["response_format"] is not None:
+            payload["response_format"] = options["response_format"]
+
+        payload.update({
+            "return_images": options["return_images"],
+            "return_related_questions": options["return_related_questions"]
+        })
+        return payload
+
+    def _sanitize_content(self, content):
+        """Sanitize string content, or the text parts of multimodal content."""
+        if isinstance(content, str):
+            return self.sanitizer(content)
+        if isinstance(content, list):
+            return [dict(part, text=self.sanitizer(part["text"]))
+                    if isinstance(part, dict) and isinstance(part.get("text"), str) else part
+                    for part in content]
+        return content
+
+    def build_request(self, messages: List[Dict[str, str]],
+                      system_prompt: Optional[str] = None, stream: bool = False,
+                      **params) -> Dict:
+        """
+        Show exactly what a call would send, without sending it.
+
+        Args:
+            messages (List[Dict[str, str]]): Conversation so far, as role/content dicts
+            system_prompt (Optional[str]): System instructions prepended to the
+                                           messages when non-empty
+            stream (bool): Describe a streaming request instead of a plain one
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Returns:
+            Dict: The endpoint URL, the headers with the API key redacted, and the
+                  JSON body with every parameter resolved
+        """
+        payload = self._build_payload(_with_system_prompt(messages, system_prompt),
+                                      stream=stream, params=params)
+        _, api_key = self._keys.current()
+        return {
+            "url": self.config.base_url,
+            "headers": redact_headers(self._get_headers(api_key)),
+            "body": payload
+        }
+
+    def _post(self, payload: Dict, timeout: float,
+              cancel: Optional[threading.Event],
+              deadline: Optional[float] = None) -> requests.Response:
+        """
+        Send a request payload to the API.
+
+        Args:
+            payload (Dict): Request body
+            timeout (float): Seconds to wait for the connection and each read
+            cancel (Optional[threading.Event]): Aborts the request when set
+            deadline (Optional[float]): time.monotonic() value by which the whole call
+                                        must finish. It bounds rate limiter waits,
+                                        shortens each attempt's timeout to the time
+                                        left, and stops retries that couldn't finish
+
+        Returns:
+            requests.Response: The raw HTTP response
+
+        Raises:
+            CacheMissError: In offline mode, before anything is sent
+        """
+        if self.offline:
+            raise CacheMissError()
+        attempt = 0
+        model = payload.get("model")
+        timing = _call_timing.get()
+        while True:
+            _check_cancelled(cancel)
+            # The model's own limiter comes first; 429s and quota are applied to it
+            limiters = [limiter for limiter in (self.model_rate_limiters.get(payload.get("model")),
+                                                self.rate_limiter) if limiter is not None]
+            waited = time.monotonic()
+            try:
+                for limiter in limiters:
+                    limiter.wait(cancel, deadline)
+            except TimeoutError as e:
+                raise requests.exceptions.Timeout(str(e))
+            if timing is not None:
+                timing.queued += time.monotonic() - waited
+            attempt_timeout = timeout
+            if deadline is not None:
+                remaining = deadline - time.monotonic()
+                if remaining <= 0:
+                    self.metrics.inc_error(model, "network")
+                    raise requests.exceptions.Timeout("Timed out before the request could be sent")
+                attempt_timeout = min(timeout, remaining)
+            retry_after = None
+            key_index, api_key = self._keys.current()
+            headers = self._get_headers(api_key)
+            logger.debug("POST %s model=%s stream=%s attempt=%d", self.config.base_url,
+                         payload.get("model"), payload.get("stream"), attempt + 1)
+            if self.debug:
+                logger.debug("Request headers: %s", json.dumps(redact_headers(headers)))
+                logger.debug("Request body: %s", json.dumps(payload))
+            started = time.monotonic()
+            if timing is not None:
+                timing.sent_at = started
+            try:
+                response = self._send_cancellable(headers, payload, attempt_timeout, cancel)
+            except (requests.exceptions.ConnectionError, requests.exceptions.Timeout) as e:
+                delay = self._retry_delay(attempt)
+                if attempt >= self.config.max_retries or _past_deadline(deadline, delay):
+                    self.metrics.inc_error(model, "network")
+                    raise
+                logger.warning("Request failed (%s); retrying", e)
+            else:
+                elapsed = time.monotonic() - started
+                logger.debug("Response status=%d in %.2fs", response.status_code, elapsed)
+                self.metrics.inc_request(model, response.status_code)
+                self.metrics.observe_latency(model, elapsed)
+                info = parse_rate_limit_headers(response.headers, payload.get("model"))
+                if info is not None:
+                    self._rate_limit_info = info
+                    if limiters and info.remaining is not None and info.reset_at is not None:
+                        limiters[0].on_quota(info.remaining, info.reset_in())
+                if response.status_code == 429:
+                    retry_after = parse_retry_after(response.headers.get("Retry-After"))
+                    # Limits are enforced per model, so back off the model's limiter if it has one
+                    if limiters:
+                        limiters[0].on_rate_limited()
+                elif response.ok:
+                    for limiter in limiters:
+                        limiter.on_success()
+                delay = self._retry_delay(attempt, retry_after)
+                # Out of retries, or the wait would run past the deadline
+                give_up = attempt >= self.config.max_retries or _past_deadline(deadline, delay)
+                if (response.status_code in (401, 429) and len(self._keys) > 1
+                        and attempt < self.config.max_retries):
+                    # A rejected key is never used again; a limited one rests as long as asked
+                    cooldown = float("inf") if response.status_code == 401 else (
+                        retry_after if retry_after is not None else KEY_COOLDOWN)
+                    if self._keys.cool_down(key_index, cooldown):
+                        logger.warning("API key %d got status %d; switching keys",
+                                       key_index + 1, response.status_code)
+                        response.close()
+                        self.metrics.inc_retry(model)
+                        attempt += 1
+                        continue
+                if response.status_code == 429:
+                    if give_up:
+                        response.close()
+                        self.metrics.inc_error(model, "429")
+                        raise RateLimitError(
+                            f"Rate limited by the API after {attempt + 1} attempt(s)",
+                            retry_after=retry_after
+                        )
+                if response.status_code not in RETRYABLE_STATUS_CODES or give_up:
+                    try:
+                        _check_cancelled(cancel)
+                        if not response.ok:
+                            self.metrics.inc_error(model, str(response.status_code))
+                            raise api_error_from_response(response)
+                    except BaseException:
+                        response.close()
+                        raise
+                    return response
+                logger.warning("API returned status %d; retrying", response.status_code)
+                response.close()
+
+            self.metrics.inc_retry(model)
+            self._sleep_before_retry(delay, cancel)
+            if timing is not None:
+                timing.queued += delay
+            attempt += 1
+
+    def _send(self, headers: Dict[str, str], payload: Dict, timeout: float) -> requests.Response:
+        """Make one HTTP attempt, passing it through the middleware chain."""
+        prepared = self.session.prepare_request(
+            requests.Request("POST", self.config.base_url, headers=headers, json=payload)
+        )
+        # Same environment handling (proxies, CA bundle) as Session.post; an explicit
+        # proxy or CA bundle wins over HTTPS_PROXY or REQUESTS_CA_BUNDLE
+        settings = self.session.merge_environment_settings(prepared.url, self.proxies, True,
+                                                           self.ca_bundle, None)
+
+        def send(request: requests.PreparedRequest) -> requests.Response:
+            # The body is always streamed so a cancelled call can stop reading early
+            return self.session.send(request, timeout=timeout, **settings)
+
+        handler = send
+        for middleware in reversed(self.middleware):
+            handler = middleware(handler)
+        return handler(prepared)
+
+    def _send_cancellable(self, headers: Dict[str, str], payload: Dict, timeout: float,
+                          cancel: Optional[threading.Event]) -> requests.Response:
+        """
+        Make one HTTP attempt, giving up as soon as cancel is set.
+
+        With a cancel event the attempt runs on a helper thread, so a call stuck
+        connecting or waiting for the response returns straight away with
+        RequestCancelled. The abandoned attempt finishes in the background, bounded
+        by its timeout, and any response it gets is closed.
+        """
+        if cancel is None:
+            return self._send(headers, payload, timeout)
+        _check_cancelled(cancel)
+        lock = threading.Lock()
+        outcome: Dict = {}
+        finished = threading.Event()
+
+        def attempt() -> None:
+            try:
+                response = self._send(headers, payload, timeout)
+            except BaseException as e:
+                outcome["error"] = e
+            else:
+                with lock:
+                    if outcome.get("abandoned"):
+                        response.close()
+                    else:
+                        outcome["response"] = response
+            finally:
+                finished.set()
+
+        # Run in a copy of the caller's context, so middleware sees its trace span
+        threading.Thread(target=contextvars.copy_context().run, args=(attempt,),
+                         daemon=True).start()
+        try:
+            while not finished.wait(CANCEL_POLL_INTERVAL):
+                if cancel.is_set():
+                    with lock:
+                        if "response" not in outcome and "error" not in outcome:
+                            outcome["abandoned"] = True
+                            raise RequestCancelled("Request cancelled")
+        except RequestCancelled:
+            raise
+        except BaseException:
+            # e.g. KeyboardInterrupt: the caller won't read the response, so don't leak it
+            with lock:
+                outcome["abandoned"] = True
+                if "response" in outcome:
+                    outcome.pop("response").close()
+            raise
+        if "error" in outcome:
+            raise outcome["error"]
+        return outcome["response"]
+
+    def _retry_delay(self, attempt: int, retry_after: Optional[float] = None) -> float:
+        """Return as long as the server asked, or the exponential backoff with jitter."""
+        if retry_after is not None:
+            return retry_after
+        delay = self.config.retry_base_delay * (2 ** attempt)
+        return delay + random.uniform(0, delay / 2)
+
+    def _sleep_before_retry(self, delay: float, cancel: Optional[threading.Event]) -> None:
+        """Wait before the next attempt, stopping early if the call is cancelled."""
+        if cancel is not None:
+            if cancel.wait(delay):
+                raise RequestCancelled("Request cancelled")
+        else:
+            time.sleep(delay)
+
+    def chat(self, messages: List[Dict[str, str]],
+             system_prompt: Optional[str] = None,
+             timeout: Optional[float] = None,
+             cancel: Optional[threading.Event] = None,
+             **params) -> Dict[str, Union[str, dict]]:
+        """
+        Send a full conversation to the Perplexity API.
+
+        If the model keeps failing with a network error, 429 or 5xx, each of
+        config.fallback_models is tried in turn before giving up.
+
+        Args:
+            messages (List[Dict[str, str]]): Conversation so far, as role/content
+                                             dicts with interleaved user and
+                                             assistant turns
+            system_prompt (Optional[str]): System instructions prepended to the
+                                           messages when non-empty
+            timeout (Optional[float]): Total seconds allowed, retries and fallback models
+                                       included; defaults to config.timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort
+                                                the call with RequestCancelled
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Returns:
+            Dict[str, Union[str, dict]]: API response
+
+        Raises:
+            NoChoicesError: If the API answered but returned no choices
+        """
+        models = [params.get("model") or self.config.model] + list(self.config.fallback_models or [])
+        start = time.monotonic()
+        # The timeout covers every model tried, not each one
+        deadline = start + (self.config.timeout if timeout is None else timeout)
+        try:
+            for index, model in enumerate(models):
+                try:
+                    response = self._chat_once(messages, system_prompt, deadline - time.monotonic(),
+                                               cancel, dict(params, model=model))
+                except (APIError, NetworkError) as e:
+                    if (index == len(models) - 1 or not _is_retryable(e)
+                            or time.monotonic() >= deadline):
+                        raise
+                    logger.warning("Model %s failed (%s); falling back to %s",
+                                   model, e, models[index + 1])
+                    continue
+                if index:
+                    logger.warning("Answered by fallback model %s", model)
+                return response
+        finally:
+            self.metrics.observe_duration(models[0], time.monotonic() - start)
+
+    def _chat_once(self, messages: List[Dict[str, str]], system_prompt: Optional[str],
+                   timeout: Optional[float], cancel: Optional[threading.Event],
+                   params: Dict) -> Dict[str, Union[str, dict]]:
+        """Send a conversation to a single model, with retries but without fallback."""
+        try:
+            payload = self._build_payload(_with_system_prompt(messages, system_prompt),
+                                          stream=False, params=params)
+            cache_key = None
+            if self.cache:
+                cache_key = self.cache.key_for(payload)
+                cached = self.cache.get(cache_key)
+                if cached is not None:
+                    logger.debug("Serving response from cache (%s)", cache_key)
+                    return cached
+                if self.offline:
+                    raise CacheMissError(cache_key)
+
+            if timeout is None:
+                timeout = self.config.timeout
+            if not self.config.deduplicate_requests:
+                return self._fetch(payload, timeout, cancel, cache_key)
+            try:
+                # Another caller's cancellation or timeout isn't ours; we send our own request
+                return self._in_flight.do(
+                    ResponseCache.key_for(payload),
+                    lambda: self._fetch(payload, timeout, cancel, cache_key),
+                    cancel=cancel, deadline=time.monotonic() + timeout,
+                    private_errors=(RequestCancelled, requests.exceptions.Timeout)
+                )
+            except TimeoutError as e:
+                raise requests.exceptions.Timeout(str(e))
+
+        except requests.exceptions.RequestException as e:
+            raise NetworkError(f"API request failed: {str(e)}")
+
+    def _fetch(self, payload: Dict, timeout: float, cancel: Optional[threading.Event],
+               cache_key: Optional[str]) -> Dict[str, Union[str, dict]]:
+        """Send a non-streaming request and parse, count and cache its response."""
+        deadline = time.monotonic() + timeout
+        with request_span(self.tracer, "perplexity.chat", payload["model"]) as span:
+            response = self._post(payload, timeout=timeout, cancel=cancel, deadline=deadline)
+            span.set_attribute("http.response.status_code", response.status_code)
+            with response:
+                body = b"".join(_iter_body(response, cancel, deadline))
+            if self.debug:
+                logger.debug("Response body: %s", body.decode("utf-8", errors="replace"))
+            result = _parse_json_body(response, body)
+            span.set_usage(result.get("usage"))
+        self._record_usage(payload["model"], result)
+        if not result.get("choices"):
+            raise NoChoicesError(result, result.get("finish_reason"))
+        if cache_key:
+            self.cache.set(cache_key, result)
+        return result
+
+    def ping(self, timeout: Optional[float] = None,
+             cancel: Optional[threading.Event] = None) -> None:
+        """
+        Check that the endpoint is reachable and accepts the API key.
+
+        Sends a one-token completion, bypassing the response cache.
+
+        Args:
+            timeout (Optional[float]): Total seconds allowed; defaults to config.timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort the check
+
+        Raises:
+            AuthenticationError: If the key is rejected
+            APIError: If the API answers with any other error status
+            NetworkError: If the API can't be reached
+        """
+        payload = self._build_payload([{"role": "user", "content": "ping"}], stream=False,
+                                      params={"max_tokens": 1})
+        timeout = timeout or self.config.timeout
+        try:
+            response = self._post(payload, timeout=timeout, cancel=cancel,
+                                  deadline=time.monotonic() + timeout)
+            response.close()
+        except requests.exceptions.RequestException as e:
+            raise NetworkError(f"API request failed: {str(e)}")
+
+    def query(self, prompt: str, system_prompt: str = DEFAULT_SYSTEM_PROMPT,
+              timeout: Optional[float] = None,
+              cancel: Optional[threading.Event] = None,
+              **params) -> Dict[str, Union[str, dict]]:
+        """
+        Send a single query to the Perplexity API.
+
+        Args:
+            prompt (str): The user's prompt
+            system_prompt (str): System instructions for the model; empty omits them
+            timeout (Optional[float]): Total seconds allowed; defaults to config.timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort
+                                                the call with RequestCancelled
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Returns:
+            Dict[str, Union[str, dict]]: API response
+        """
+        return self.chat([{"role": "user", "content": prompt}], system_prompt=system_prompt,
+                         timeout=timeout, cancel=cancel, **params)
+
+    def continue_conversation(self, history: List[Dict[str, str]],
+                              user_message: Union[str, List[Dict]],
+                              system_prompt: Optional[str] = None,
+                              timeout: Optional[float] = None,
+                              cancel: Optional[threading.Event] = None,
+                              **params) -> Tuple[Dict[str, Union[str, dict]], List[Dict[str, str]]]:
+        """
+        Add a user turn to a conversation and send it.
+
+        Args:
+            history (List[Dict[str, str]]): Conversation so far; not modified
+            user_message (Union[str, List[Dict]]): The next user message, as text or as
+                                                   content parts from message_content()
+            system_prompt (Optional[str]): System instructions added to the start of
+                                           the history if it doesn't have any yet
+            timeout (Optional[float]): Total seconds allowed; defaults to config.timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort the call
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Returns:
+            Tuple[Dict, List[Dict[str, str]]]: The API response and the updated
+                                               history including the assistant reply
+        """
+        messages = list(history)
+        if not any(message.get("role") == "system" for message in messages):
+            messages = _with_system_prompt(messages, system_prompt)
+        messages.append({"role": "user", "content": user_message})
+        response = self.chat(messages, timeout=timeout, cancel=cancel, **params)
+        choices = response.get('choices') or []
+        if choices:
+            reply = choices[0].get('message') or {}
+            messages.append({"role": "assistant", "content": reply.get('content', '')})
+        return response, messages
+
+    def chat_batch(self, conversations: List[List[Dict[str, str]]], concurrency: int = 4,
+                   system_prompt: Optional[str] = None,
+                   cancel: Optional[threading.Event] = None,
+                   **params) -> List[BatchResult]:
+        """
+        Send several conversations concurrently, sharing the client's rate limiter.
+
+        Args:
+            conversations (List[List[Dict[str, str]]]): One message list per request
+            concurrency (int): Maximum requests in flight at once
+            system_prompt (Optional[str]): System instructions prepended to each request
+            cancel (Optional[threading.Event]): Set to abort requests still running or queued
+            **params: Per-call overrides applied to every request
+
+        Returns:
+            List[BatchResult]: One result per conversation, in input order
+        """
+        results: List[BatchResult] = [BatchResult()] * len(conversations)
+        for index, result in self.iter_chat_batch(conversations, concurrency=concurrency,
+                                                  system_prompt=system_prompt, cancel=cancel,
+                                                  **params):
+            results[index] = result
+        return results
+
+    def iter_chat_batch(self, conversations: List[List[Dict[str, str]]], concurrency: int = 4,
+                        system_prompt: Optional[str] = None,
+                        cancel: Optional[threading.Event] = None,
+                        **params) -> Generator[Tuple[int, BatchResult], None, None]:
+        """
+        Send several conversations concurrently, yielding each result as soon as it arrives.
+
+        Args:
+            conversations (List[List[Dict[str, str]]]): One message list per request
+            concurrency (int): Maximum requests in flight at once
+            system_prompt (Optional[str]): System instructions prepended to each request
+            cancel (Optional[threading.Event]): Set to abort requests still running or queued
+            **params: Per-call overrides applied to every request
+
+        Yields:
+            Tuple[int, BatchResult]: Index of the conversation and its result, in
+                                     completion order
+        """
+        return self._iter_concurrently([(messages, params) for messages in conversations],
+                                       concurrency, system_prompt, cancel)
+
+    def compare(self, messages: List[Dict[str, str]], models: List[str],
+                concurrency: Optional[int] = None,
+                system_prompt: Optional[str] = None,
+                cancel: Optional[threading.Event] = None,
+                **params) -> List[BatchResult]:
+        """
+        Send the same conversation to several models concurrently.
+
+        Each model's requests go through its own rate limiter as well as the
+        client's, and one model failing doesn't stop the others.
+
+        Args:
+            messages (List[Dict[str, str]]): Conversation to send to every model
+            models (List[str]): Models to ask
+            concurrency (Optional[int]): Maximum requests in flight at once; defaults
+                                         to one per model
+            system_prompt (Optional[str]): System instructions prepended to each request
+            cancel (Optional[threading.Event]): Set to abort requests still running or queued
+            **params: Per-call overrides applied to every request
+
+        Returns:
+            List[BatchResult]: One result per model, in the order given
+        """
+        for model in models:
+            if get_model(model) is None:
+                raise ValueError(f"Unknown model {model!r}")
+        results: List[BatchResult] = [BatchResult()] * len(models)
+        jobs = [(messages, dict(params, model=model)) for model in models]
+        for index, result in self._iter_concurrently(jobs, concurrency or len(models) or 1,
+                                                     system_prompt, cancel):
+            results[index] = result
+        return results
+
+    def _iter_concurrently(self, jobs: List[Tuple[List[Dict[str, str]], Dict]], concurrency: int,
+                           system_prompt: Optional[str], cancel: Optional[threading.Event]
+                           ) -> Generator[Tuple[int, BatchResult], None, None]:
+        """Run chat() for each (messages, params) job, yielding results as they complete."""
+        if concurrency < 1:
+            raise ValueError("concurrency must be at least 1")
+
+        def run(messages: List[Dict[str, str]], params: Dict) -> BatchResult:
+            # Each job runs in its own context copy, so this timing is only its own
+            timing = _CallTiming()
+            _call_timing.set(timing)
+            start = time.monotonic()
+            result = BatchResult()
+            try:
+                result.response = self.chat(messages, system_prompt=system_prompt,
+                                            cancel=cancel, **params)
+            except Exception as e:
+                result.error = e
+            end = time.monotonic()
+            result.duration = end - start
+            result.latency = end - timing.sent_at if timing.sent_at is not None else None
+            result.queue_time = timing.queued
+            return result
+
+        with ThreadPoolExecutor(max_workers=concurrency) as executor:
+            # Run each request in a copy of the caller's context, so trace spans nest under theirs
+            futures = {executor.submit(contextvars.copy_context().run, run, messages, params): index
+                       for index, (messages, params) in enumerate(jobs)}
+            for future in as_completed(futures):
+                yield futures[future], future.result()
+
+    def query_batch(self, prompts: List[str], system_prompt: str = DEFAULT_SYSTEM_PROMPT,
+                    concurrency: int = 4,
+                    cancel: Optional[threading.Event] = None,
+                    **params) -> List[BatchResult]:
+        """
+        Send several single-prompt queries concurrently.
+
+        Args:
+            prompts (List[str]): The user's prompts
+            system_prompt (str): System instructions for the model; empty omits them
+            concurrency (int): Maximum requests in flight at once
+            cancel (Optional[threading.Event]): Set to abort requests still running or queued
+            **params: Per-call overrides applied to every request
+
+        Returns:
+            List[BatchResult]: One result per prompt, in input order
+        """
+        return self.chat_batch([[{"role": "user", "content": prompt}] for prompt in prompts],
+                               concurrency=concurrency, system_prompt=system_prompt,
+                               cancel=cancel, **params)
+
+    def iter_query_batch(self, prompts: List[str], system_prompt: str = DEFAULT_SYSTEM_PROMPT,
+                         concurrency: int = 4,
+                         cancel: Optional[threading.Event] = None,
+                         **params) -> Generator[Tuple[int, BatchResult], None, None]:
+        """
+        Send several single-prompt queries concurrently, yielding results as they arrive.
+
+        Args:
+            prompts (List[str]): The user's prompts
+            system_prompt (str): System instructions for the model; empty omits them
+            concurrency (int): Maximum requests in flight at once
+            cancel (Optional[threading.Event]): Set to abort requests still running or queued
+            **params: Per-call overrides applied to every request
+
+        Yields:
+            Tuple[int, BatchResult]: Index of the prompt and its result, in completion order
+        """
+        return self.iter_chat_batch([[{"role": "user", "content": prompt}] for prompt in prompts],
+                                    concurrency=concurrency, system_prompt=system_prompt,
+                                    cancel=cancel, **params)
+
+    def summarize(self, text: str, model: Optional[str] = None,
+                  concurrency: int = 4,
+                  cancel: Optional[threading.Event] = None,
+                  **params) -> str:
+        """
+        Summarize text of any length, even more than the model's context window.
+
+        Text that fits is summarized in one request. Longer text is split into
+        window-sized chunks that are summarized concurrently, and the partial
+        summaries are then combined, repeatedly if they still don't fit.
+
+        Args:
+            text (str): Text to summarize
+            model (Optional[str]): Model to use; defaults to config.model
+            concurrency (int): Maximum chunk requests in flight at once
+            cancel (Optional[threading.Event]): Set to abort the remaining requests
+            **params: Per-call overrides applied to every request
+
+        Returns:
+            str: The summary
+
+        Raises:
+            RuntimeError: If combining the summaries doesn't make them any shorter
+        """
+        model = model or self.config.model
+        info = get_model(model)
+        if info is None:
+            raise ValueError(f"No context window known for model: {model}")
+        # Leave room in each request for the instructions and the summary itself
+        answer_tokens = params.get("max_tokens") or self.config.max_tokens or SUMMARY_ANSWER_TOKENS
+        budget = info.context_window - answer_tokens - SUMMARY_OVERHEAD_TOKENS
+        if budget < answer_tokens:
+            raise ValueError(f"max_tokens {answer_tokens} leaves no room for input to {model}")
+
+        prompt = SUMMARIZE_PROMPT
+        previous = None
+        while True:
+            chunks = split_text(text, budget)
+            if not chunks:
+                raise ValueError("Nothing to summarize")
+            # Each round must shrink the text, or combining would never finish
+            if previous is not None and len(chunks) >= previous:
+                raise RuntimeError(f"Summaries of {previous} chunks still fill {len(chunks)};"
+                                   " the model isn't condensing the text")
+            previous = len(chunks)
+            # The cap keeps every summary within the room reserved for it
+            results = self.chat_batch([[{"role": "user", "content": chunk}] for chunk in chunks],
+                                      concurrency=concurrency, system_prompt=prompt,
+                                      cancel=cancel,
+                                      **dict(params, model=model, max_tokens=answer_tokens))
+            summaries = []
+            for result in results:
+                if result.error is not None:
+                    raise result.error
+                choices = result.response.get("choices") or []
+                summaries.append(choices[0]["message"]["content"] if choices else "")
+            if len(chunks) == 1:
+                return summaries[0]
+            logger.debug("Summarized %d chunks; combining", len(chunks))
+            text = "\n\n".join(summaries)
+            prompt = COMBINE_SUMMARIES_PROMPT
+
+    def stream_chat(self, messages: List[Dict[str, str]],
+                    system_prompt: Optional[str] = None,
+                    timeout: Optional[float] = None,
+                    cancel: Optional[threading.Event] = None,
+                    **params) -> Generator[Dict, None, None]:
+        """
+        Stream the response to a full conversation from the Perplexity API.
+
+        Args:
+            messages (List[Dict[str, str]]): Conversation so far, as role/content dicts
+            system_prompt (Optional[str]): System instructions prepended to the
+                                           messages when non-empty
+            timeout (Optional[float]): Seconds to wait between chunks; defaults to
+                                       config.stream_timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort
+                                                the stream with RequestCancelled
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Yields:
+            Dict: Each chunk of the streaming response as a parsed dictionary
+
+        Raises:
+            APIError: If the API sends an error partway through the stream; a
+                      RateLimitError or AuthenticationError when its code says so
+        """
+        try:
+            payload = self._build_payload(_with_system_prompt(messages, system_prompt),
+                                          stream=True, params=params)
+
+            if timeout is None:
+                timeout = self.config.stream_timeout
+            # Not made current: the span stays open across yields to the caller
+            with request_span(self.tracer, "perplexity.stream", payload["model"], current=False) as span:
+                response = self._post(payload, timeout=timeout, cancel=cancel)
+                span.set_attribute("http.response.status_code", response.status_code)
+                # Usage arrives on the final chunks; it's counted once the stream ends
+                last_usage: Dict = {}
+                with response:
+                    for line in _iter_lines(response, cancel):
+                        _check_cancelled(cancel)
+                        if not line or not line.strip():
+                            continue
+                        data = line.decode('utf-8')
+                        if self.debug:
+                            logger.debug("Stream line: %s", data)
+                        # SSE comments and non-data fields carry no payload
+                        if data.startswith((':', 'event:', 'id:', 'retry:')):
+                            continue
+                        # Handle potential data: prefix in SSE
+                        if data.startswith('data:'):
+                            data = data[5:]
+                        data = data.strip()
+                        if data == '[DONE]':
+                            break
+                        try:
+                            chunk = json.loads(data)
+                        except json.JSONDecodeError:
+                            continue
+                        if isinstance(chunk, dict) and chunk.get('error'):
+                            raise stream_error_from_chunk(chunk['error'], response.status_code)
+                        if isinstance(chunk, dict) and chunk.get('usage'):
+                            last_usage = chunk
+                        yield chunk
+                span.set_usage(last_usage.get("usage"))
+            self._record_usage(payload["model"], last_usage)
+
+        except requests.exceptions.RequestException as e:
+            raise NetworkError(f"Streaming request failed: {str(e)}")
+
+    def stream_query(self, prompt: str, system_prompt: str = DEFAULT_SYSTEM_PROMPT,
+                     timeout: Optional[float] = None,
+                     cancel: Optional[threading.Event] = None,
+                     **params) -> Generator[Dict, None, None]:
+        """
+        Stream responses from the Perplexity API.
+
+        Args:
+            prompt (str): The user's prompt
+            system_prompt (str): System instructions for the model; empty omits them
+            timeout (Optional[float]): Seconds to wait between chunks; defaults to
+                                       config.stream_timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort
+                                                the stream with RequestCancelled
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Yields:
+            Dict: Each chunk of the streaming response as a parsed dictionary
+        """
+        return self.stream_chat([{"role": "user", "content": prompt}], system_prompt=system_prompt,
+                                timeout=timeout, cancel=cancel, **params)
+
+    def stream_text(self, prompt: str, system_prompt: str = DEFAULT_SYSTEM_PROMPT,
+                    timeout: Optional[float] = None,
+                    cancel: Optional[threading.Event] = None,
+                    **params) -> Generator[str, None, None]:
+        """
+        Stream only the generated text from the Perplexity API.
+
+        Args:
+            prompt (str): The user's prompt
+            system_prompt (str): System instructions for the model; empty omits them
+            timeout (Optional[float]): Seconds to wait between chunks; defaults to
+                                       config.stream_timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort the stream
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Yields:
+            str: Each content delta as it arrives
+        """
+        for chunk in self.stream_query(prompt, system_prompt, timeout=timeout, cancel=cancel, **params):
+            content = _delta_content(chunk)
+            if content:
+                yield content
+
+    def stream_to(self, messages: List[Dict[str, str]], writer: TextIO,
+                  system_prompt: Optional[str] = None,
+                  timeout: Optional[float] = None,
+                  cancel: Optional[threading.Event] = None,
+                  **params) -> Dict[str, Union[str, dict]]:
+        """
+        Stream a response into a text stream such as sys.stdout or an open file.
+
+        Args:
+            messages (List[Dict[str, str]]): Conversation so far, as role/content dicts
+            writer (TextIO): Receives each content delta as it arrives; flushed after
+                             every write so output appears live
+            system_prompt (Optional[str]): System instructions prepended to the
+                                           messages when non-empty
+            timeout (Optional[float]): Seconds to wait between chunks; defaults to
+                                       config.stream_timeout
+            cancel (Optional[threading.Event]): Set from another thread to abort the stream
+            **params: Per-call overrides such as temperature=0 or model="..."
+
+        Returns:
+            Dict[str, Union[str, dict]]: The assembled response, shaped like the
+                                         result of chat(), including any usage,
+                                         citations and finish reason the stream sent
+
+        Raises:
+            StreamInterruptedError: If the stream times out or disconnects partway;
+                                    its partial_response holds what arrived so far,
+                                    which may be incomplete
+        """
+        parts: List[str] = []
+        meta: Dict = {}
+        finish_reason = None
+        start = time.monotonic()
+        try:
+            for chunk in self.stream_chat(messages, system_prompt=system_prompt,
+                                          timeout=timeout, cancel=cancel, **params):
+                # Metadata usually arrives on the final chunk; keep the latest value seen
+                for key in STREAM_METADATA_KEYS:
+                    if chunk.get(key):
+                        meta[key] = chunk[key]
+                choices = chunk.get("choices") or []
+                if choices and choices[0].get("finish_reason"):
+                    finish_reason = choices[0]["finish_reason"]
+                content = _delta_content(chunk)
+                if content:
+                    parts.append(content)
+                    writer.write(content)
+                    if hasattr(writer, "flush"):
+                        writer.flush()
+        except NetworkError as e:
+            raise StreamInterruptedError(str(e), _assemble_response(meta, parts, None)) from e
+        finally:
+            self.metrics.observe_duration(params.get("model") or self.config.model,
+                                          time.monotonic() - start)
+
+        return _assemble_response(meta, parts, finish_reason)
+
+def _assemble_response(meta: Dict, parts: List[str],
+                       finish_reason: Optional[str]) -> Dict[str, Union[str, dict]]:
+    """Build a chat()-shaped response from streamed content and metadata."""
+    meta = dict(meta)
+    response = {
+        "id": meta.pop("id", None),
+        "model": meta.pop("model", None),
+        "choices": [{
+            "index": 0,
+            "finish_reason": finish_reason,
+            "message": {"role": "assistant", "content": "".join(parts)}
+        }]
+    }
+    response.update(meta)
+    return response
+
+def api_error_from_response(response: requests.Response) -> APIError:
+    """
+    Build an APIError from a failed response.
+
+    Args:
+        response (requests.Response): Response with a non-2xx status
+
+    Returns:
+        APIError: Error carrying the status code and the most useful message available
+    """
+    body = response.text
+    logger.debug("Error response body: %s", body)
+    message = None
+    try:
+        data = json.loads(body)
+    except ValueError:
+        data = None
+    if isinstance(data, dict):
+        error = data.get("error") or data.get("detail")
+        if isinstance(error, dict):
+            message = error.get("message") or json.dumps(error)
+        elif error:
+            message = str(error)
+    if not message:
+        snippet = _snippet(body)
+        message = snippet or response.reason or "no response body"
+    if response.status_code in (401, 403):
+        return AuthenticationError(response.status_code, message)
+    return APIError(response.status_code, message)
+
+def stream_error_from_chunk(error, status_code: int) -> APIError:
+    """
+    Build an APIError from an error the API sent partway through a stream.
+
+    Args:
+        error: The chunk's "error" value, usually a dict with message and code
+        status_code (int): HTTP status of the stream, used when the error has no
+                           numeric code
+
+    Returns:
+        APIError: Error carrying the error's code as its status, typed like
+                  api_error_from_response for 401, 403 and 429
+    """
+    code = error.get("code") if isinstance(error, dict) else None
+    if isinstance(error, dict):
+        message = error.get("message") or json.dumps(error)
+    else:
+        message = str(error)
+    status = code if _is_int(code) else status_code
+    if code is not None and not _is_int(code):
+        message = f"{message} ({code})"
+    message = f"error during stream: {message}"
+    if status == 429:
+        return RateLimitError(message)
+    if status in (401, 403):
+        return AuthenticationError(status, message)
+    return APIError(status, message)
+
+def _parse_json_body(response: requests.Response, body: bytes) -> Dict:
+    """
+    Parse a successful response body, rejecting anything that isn't a JSON object.
+
+    Proxies and gateways sometimes answer 200 with an HTML page; that becomes an
+    APIError with the content type and a snippet instead of a bare parse error.
+
+    A body declared as gzip is already decompressed by requests; some proxies
+    gzip it without saying so, so compressed bytes are recognised and expanded too.
+    """
+    content_type = response.headers.get("Content-Type", "")
+    if body[:2] == GZIP_MAGIC:
+        try:
+            body = gzip.decompress(body)
+        except (OSError, EOFError):
+            pass  # Reported below as a body that isn't JSON
+    text = body.decode("utf-8", errors="replace")
+    try:
+        data = json.loads(text)
+    except ValueError:
+        data = None
+    if not isinstance(data, dict):
+        raise APIError(response.status_code,
+                       f"Expected a JSON response but got {content_type or 'no content type'}: "
+                       f"{_snippet(text) or 'empty body'}")
+    return data
+
+def _snippet(text: str) -> str:
+    """Shorten a response body for inclusion in an error message."""
+    text = text.strip()
+    if len(text) <= ERROR_SNIPPET_LENGTH:
+        return text
+    return text[:ERROR_SNIPPET_LENGTH] + "..."
+
+def redact_headers(headers: Dict[str, str]) -> Dict[str, str]:
+    """
+    Return a copy of the headers that is safe to log.
+
+    Args:
+        headers (Dict[str, str]): Request headers
+
+    Returns:
+        Dict[str, str]: Headers with credentials reduced to their last four characters
+    """
+    redacted = dict(headers)
+    for name in redacted:
+        if name.lower() == "authorization":
+            value = redacted[name]
+            redacted[name] = "Bearer ****" + value[-4:] if len(value) > 15 else "****"
+    return redacted
+
+def parse_retry_after(value: Optional[str]) -> Optional[float]:
+    """
+    Parse a Retry-After header given either as seconds or as an HTTP date.
+
+    Args:
+        value (Optional[str]): Raw header value
+
+    Returns:
+        Optional[float]: Seconds to wait, or None if the header is missing or invalid
+    """
+    if not value:
+        return None
+    value = value.strip()
+    try:
+        return max(0.0, float(value))
+    except ValueError:
+        pass
+    try:
+        retry_at = parsedate_to_datetime(value)
+    except (TypeError, ValueError):
+        return None
+    if retry_at.tzinfo is None:
+        retry_at = retry_at.replace(tzinfo=timezone.utc)
+    return max(0.0, (retry_at - datetime.now(timezone.utc)).total_seconds())
+
+def parse_rate_limit_headers(headers, model: Optional[str] = None) -> Optional[RateLimitInfo]:
+    """
+    Read the X-RateLimit-Limit, -Remaining and -Reset response headers.
+
+    The reset may be given as seconds from now or as a Unix timestamp.
+
+    Args:
+        headers: Response headers (case-insensitive mapping)
+        model (Optional[str]): Model the request was for
+
+    Returns:
+        Optional[RateLimitInfo]: The parsed values, or None if none of the headers
+                                 were present; invalid values are left as None
+    """
+    limit = _header_int(headers.get("X-RateLimit-Limit"))
+    remaining = _header_int(headers.get("X-RateLimit-Remaining"))
+    reset = headers.get("X-RateLimit-Reset")
+    if limit is None and remaining is None and not reset:
+        return None
+    now = time.time()
+    reset_at = None
+    try:
+        seconds = float(reset) if reset else None
+    except ValueError:
+        seconds = None
+    if seconds is not None and seconds >= 0:
+        # Anything past 2001 in seconds since the epoch is a timestamp, not a delay
+        reset_at = seconds if seconds > 1e9 else now + seconds
+    return RateLimitInfo(model=model, limit=limit, remaining=remaining, reset_at=reset_at,
+                         updated_at=now)
+
+def _header_int(value: Optional[str]) -> Optional[int]:
+    """Parse a non-negative integer header, or return None."""
+    try:
+        number = int(value.strip())
+    except (AttributeError, ValueError):
+        return None
+    return number if number >= 0 else None
+
+def _check_context_window(messages: List[Dict[str, str]], model: str,
+                          max_tokens: Optional[int]) -> None:
+    """Raise ValueError if the messages plus the completion budget won't fit the model."""
+    info = get_model(model)
+    if info is None:
+        return
+    needed = estimate_tokens(messages) + (max_tokens or 0)
+    if needed > info.context_window:
+        raise ValueError(f"Request needs about {needed} tokens, which exceeds the "
+                         f"{info.context_window}-token context window of {model}")
+
+def _is_retryable(error: Exception) -> bool:
+    """Whether a failed request might succeed against another model."""
+    if isinstance(error, APIError):
+        return error.status_code in RETRYABLE_STATUS_CODES
+    return isinstance(error, NetworkError)
+
+def _warn_deprecated(info: ModelInfo) -> None:
+    """Log that a deprecated model is in use, suggesting its replacement if there is one."""
+    if info.replaced_by:
+        logger.warning("Model %s is deprecated and may stop working; use %s instead",
+                       info.name, info.replaced_by)
+    else:
+        logger.warning("Model %s is deprecated and may stop working", info.name)
+
+def _with_system_prompt(messages: List[Dict[str, str]],
+                        system_prompt: Optional[str]) -> List[Dict[str, str]]:
+    """Return the messages with a system message prepended if one was given."""
+    if not system_prompt:
+        return list(messages)
+    return [{"role": "system", "content": system_prompt}] + list(messages)
+
+def _validate_params(options: Dict) -> None:
+    """Reject request parameters the API would refuse, before anything is sent."""
+    max_tokens = options["max_tokens"]
+    if max_tokens is not None and not (_is_int(max_tokens) and max_tokens > 0):
+        raise ValueError(f"max_tokens must be a positive integer, got {max_tokens!r}")
+    n = options["n"]
+    if n is not None and not (_is_int(n) and n > 0):
+        raise ValueError(f"n must be a positive integer, got {n!r}")
+    top_p = options["top_p"]
+    if top_p is not None and not (_is_number(top_p) and 0 <= top_p <= 1):
+        raise ValueError(f"top_p must be between 0 and 1, got {top_p!r}")
+    top_k = options["top_k"]
+    if top_k is not None and not (_is_int(top_k) and top_k >= 0):
+        raise ValueError(f"top_k must be a non-negative integer, got {top_k!r}")
+    for name in ("presence_penalty", "frequency_penalty"):
+        penalty = options[name]
+        if penalty is not None and not (_is_number(penalty) and -2 <= penalty <= 2):
+            raise ValueError(f"{name} must be between -2.0 and 2.0, got {penalty!r}")
+    domains = options["search_domain_filter"]
+    if domains is not None:
+        # A bare string would serialise as a string rather than a list of domains
+        if isinstance(domains, str) or not isinstance(domains, (list, tuple)):
+            raise ValueError(f"search_domain_filter must be a list of domains, got {domains!r}")
+        for domain in domains:
+            if not isinstance(domain, str) or not domain.lstrip("-"):
+                raise ValueError(f"Invalid domain in search_domain_filter: {domain!r}")
+    response_format = options["response_format"]
+    if response_format is not None and not (isinstance(response_format, dict)
+                                            and response_format.get("type")):
+        raise ValueError(f"response_format must be a dict with a type, got {response_format!r}")
+    recency = options["search_recency_filter"]
+    if recency and recency not in SEARCH_RECENCY_FILTERS:
+        raise ValueError(f"search_recency_filter must be one of {', '.join(SEARCH_RECENCY_FILTERS)}, "
+                         f"got {recency!r}")
+
+def _is_int(value) -> bool:
+    """Return True for ints, but not bools."""
+    return isinstance(value, int) and not isinstance(value, bool)
+
+def _is_number(value) -> bool:
+    """Return True for ints and floats, but not bools."""
+    return isinstance(value, (int, float)) and not isinstance(value, bool)
+
+def finish_reason(response: Dict, index: int = 0) -> Optional[str]:
+    """
+    Return why generation stopped for one choice of a response.
+
+    Args:
+        response (Dict): Parsed API response, or one assembled by stream_to
+        index (int): Which choice to look at
+
+    Returns:
+        Optional[str]: e.g. "stop", or "length" when max_tokens cut the answer off;
+                       None if the response has no such choice or didn't say
+    """
+    choices = response.get('choices') or []
+    if index >= len(choices):
+        return None
+    return choices[index].get('finish_reason')
+
+def is_truncated(response: Dict) -> bool:
+    """Return True if any choice in the response was cut off by max_tokens."""
+    choices = response.get('choices') or []
+    return any(choice.get('finish_reason') == FINISH_REASON_LENGTH for choice in choices)
+
+def _delta_content(chunk: Dict) -> Optional[str]:
+    """Return the text delta carried by a stream chunk, if any."""
+    choices = chunk.get('choices') or []
+    if not choices:
+        return None
+    return (choices[0].get('delta') or {}).get('content')
+
+def _check_cancelled(cancel: Optional[threading.Event]) -> None:
+    """Raise RequestCancelled if the caller has signalled cancellation."""
+    if cancel is not None and cancel.is_set():
+        raise RequestCancelled("Request cancelled")
+
+def _past_deadline(deadline: Optional[float], delay: float) -> bool:
+    """Return True if waiting delay seconds would leave no time before the deadline."""
+    return deadline is not None and time.monotonic() + delay >= deadline
+
+def _iter_body(response: requests.Response, cancel: Optional[threading.Event],
+               deadline: float) -> Generator[bytes, None, None]:
+    """Read a response body in chunks, stopping on cancellation or once the deadline passes."""
+    with _close_on_cancel(response, cancel):
+        try:
+            for chunk in response.iter_content(chunk_size=8192):
+                _check_cancelled(cancel)
+                if time.monotonic() > deadline:
+                    raise requests.exceptions.Timeout("Timed out reading the response body")
+                yield chunk
+        except Exception:
+            _check_cancelled(cancel)  # The read failed because cancel closed the response
+            raise
+
+def _iter_lines(response: requests.Response,
+                cancel: Optional[threading.Event]) -> Generator[bytes, None, None]:
+    """Read a streaming body line by line, stopping as soon as cancel is set."""
+    with _close_on_cancel(response, cancel):
+        try:
+            yield from response.iter_lines()
+        except Exception:
+            _check_cancelled(cancel)  # The read failed because cancel closed the response
+            raise
+
+@contextmanager
+def _close_on_cancel(response: requests.Response, cancel: Optional[threading.Event]):
+    """Close the response from a helper thread once cancel is set, unblocking any read."""
+    if cancel is None:
+        yield
+        return
+    finished = threading.Event()
+
+    def watch() -> None:
+        while not finished.wait(CANCEL_POLL_INTERVAL):
+            if cancel.is_set():
+                response.close()
+                return
+
+    threading.Thread(target=watch, daemon=True).start()
+    try:
+        yield
+    finally:
+        finished.set()
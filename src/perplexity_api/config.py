@@ -0,0 +1,60 @@
+import json
+import os
+from typing import Any, Dict, Optional
+
+from .models import get_model
+
+# PerplexityConfig fields that may be set from the config file
+CONFIG_FILE_KEYS = (
+    "model", "temperature", "top_p", "top_k", "max_tokens", "presence_penalty",
+    "frequency_penalty", "stop", "n", "search_domain_filter", "search_recency_filter",
+    "return_images", "return_related_questions", "rate_limit", "model_rate_limits",
+    "adaptive_rate_limit", "min_rate_limit", "max_rate_limit", "timeout",
+    "stream_timeout", "max_retries", "retry_base_delay", "base_url", "fallback_models",
+    "response_format", "pool_connections", "pool_maxsize"
+)
+
+def default_config_path() -> str:
+    """Return the config file location, honouring XDG_CONFIG_HOME."""
+    base = os.environ.get("XDG_CONFIG_HOME") or os.path.join(os.path.expanduser("~"), ".config")
+    return os.path.join(base, "pplx", "config.json")
+
+def load_config(path: Optional[str] = None) -> Dict[str, Any]:
+    """
+    Load default settings from a JSON config file.
+
+    Example file:
+        {"model": "sonar-pro", "temperature": 0.5, "rate_limit": 2}
+
+    Args:
+        path (Optional[str]): File to read; defaults to ~/.config/pplx/config.json
+
+    Returns:
+        Dict[str, Any]: Settings keyed by PerplexityConfig field name; empty if the
+                        file doesn't exist
+    """
+    path = path or default_config_path()
+    try:
+        with open(path, encoding="utf-8") as f:
+            settings = json.load(f)
+    except FileNotFoundError:
+        return {}
+    except json.JSONDecodeError as e:
+        raise ValueError(f"Invalid config file {path}: {e}")
+
+    if not isinstance(settings, dict):
+        raise ValueError(f"Invalid config file {path}: expected a JSON object")
+    unknown = sorted(set(settings) - set(CONFIG_FILE_KEYS))
+    if unknown:
+        raise ValueError(f"Unknown setting(s) in {path}: {', '.join(unknown)}")
+    if "model" in settings and get_model(settings["model"]) is None:
+        raise ValueError(f"Unknown model in {path}: {settings['model']}")
+    for model in settings.get("fallback_models") or []:
+        if get_model(model) is None:
+            raise ValueError(f"Unknown fallback model in {path}: {model}")
+    for model, rate in (settings.get("model_rate_limits") or {}).items():
+        if get_model(model) is None:
+            raise ValueError(f"Unknown model in {path} model_rate_limits: {model}")
+        if not isinstance(rate, (int, float)) or rate < 0:
+            raise ValueError(f"Invalid rate limit in {path} for {model}: {rate}")
+    return settings
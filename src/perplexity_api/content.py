@@ -0,0 +1,68 @@
+import base64
+import mimetypes
+from typing import Any, Dict, List, Sequence, Union
+
+# Sources passed to image_part as-is rather than read from disk
+_URL_PREFIXES = ("http://", "https://", "data:")
+
+def text_part(text: str) -> Dict[str, Any]:
+    """Build a text content part."""
+    return {"type": "text", "text": text}
+
+def image_part(source: str) -> Dict[str, Any]:
+    """
+    Build an image content part for multimodal models.
+
+    Args:
+        source (str): Image URL (http, https or data:), or the path of a local
+                      image file to embed as base64
+
+    Returns:
+        Dict[str, Any]: An image_url content part
+
+    Raises:
+        OSError: If the file can't be read
+        ValueError: If the file isn't a recognised image type
+    """
+    if source.startswith(_URL_PREFIXES):
+        return {"type": "image_url", "image_url": {"url": source}}
+    mime_type, _ = mimetypes.guess_type(source)
+    if not mime_type or not mime_type.startswith("image/"):
+        raise ValueError(f"Not an image file: {source}")
+    with open(source, "rb") as f:
+        data = base64.b64encode(f.read()).decode("ascii")
+    return {"type": "image_url", "image_url": {"url": f"data:{mime_type};base64,{data}"}}
+
+def message_content(text: str, images: Sequence[str] = ()) -> Union[str, List[Dict[str, Any]]]:
+    """
+    Build message content from text and any attached images.
+
+    Args:
+        text (str): The message text
+        images (Sequence[str]): Image URLs or file paths, as accepted by image_part
+
+    Returns:
+        Union[str, List[Dict[str, Any]]]: The text alone when there are no images,
+                                          so plain messages are unchanged; otherwise
+                                          a list of content parts
+    """
+    if not images:
+        return text
+    return [text_part(text)] + [image_part(source) for source in images]
+
+def content_text(content: Any) -> str:
+    """
+    Return the text of message content, whether a string or a list of parts.
+
+    Args:
+        content (Any): A message's content
+
+    Returns:
+        str: The text, with the text parts of a list joined by newlines
+    """
+    if isinstance(content, str):
+        return content
+    if isinstance(content, list):
+        return "\n".join(part.get("text", "") for part in content
+                         if isinstance(part, dict) and part.get("type") == "text")
+    return "" if content is None else str(content)
@@ -0,0 +1,149 @@
+import json
+from typing import Dict, List, TextIO
+
+from .tokens import estimate_tokens
+
+# Roles the API accepts in a conversation
+ROLE_SYSTEM = "system"
+ROLE_USER = "user"
+ROLE_ASSISTANT = "assistant"
+VALID_ROLES = (ROLE_SYSTEM, ROLE_USER, ROLE_ASSISTANT)
+
+# OpenAI's newer name for system instructions
+_OPENAI_DEVELOPER_ROLE = "developer"
+
+def validate_messages(messages: List[Dict[str, str]]) -> None:
+    """
+    Check that a conversation can be sent: at least one message, each with a
+    known role and some content.
+
+    Args:
+        messages (List[Dict[str, str]]): Messages as role/content dicts
+
+    Raises:
+        ValueError: Naming the first problem found
+    """
+    if not messages:
+        raise ValueError("At least one message is required")
+    for index, message in enumerate(messages):
+        if not isinstance(message, dict):
+            raise ValueError(f"Message {index} is not a dict: {message!r}")
+        role = message.get("role")
+        if role not in VALID_ROLES:
+            hint = ""
+            if isinstance(role, str) and role.strip().lower() in VALID_ROLES:
+                hint = f" (did you mean {role.strip().lower()!r}?)"
+            raise ValueError(f"Message {index} has unknown role {role!r}{hint}; "
+                             f"expected one of: {', '.join(VALID_ROLES)}")
+        if "content" not in message:
+            raise ValueError(f"Message {index} has no content")
+
+def save_conversation(path: str, history: List[Dict[str, str]]) -> None:
+    """
+    Write a conversation to a human-readable JSON file, replacing any existing file.
+
+    Args:
+        path (str): Destination file
+        history (List[Dict[str, str]]): Messages as role/content dicts
+    """
+    with open(path, "w", encoding="utf-8") as f:
+        json.dump(history, f, indent=2, ensure_ascii=False)
+        f.write("\n")
+
+def load_conversation(path: str) -> List[Dict[str, str]]:
+    """
+    Read a conversation saved by save_conversation.
+
+    Args:
+        path (str): File to read
+
+    Returns:
+        List[Dict[str, str]]: Messages as role/content dicts
+
+    Raises:
+        ValueError: If the file isn't a conversation validate_messages accepts
+    """
+    with open(path, encoding="utf-8") as f:
+        try:
+            history = json.load(f)
+        except json.JSONDecodeError as e:
+            raise ValueError(f"Invalid conversation file {path}: {e}")
+
+    if not isinstance(history, list):
+        raise ValueError(f"Invalid conversation file {path}: expected a list of messages")
+    # The same checks as at send time, so a file that loads can be continued
+    try:
+        validate_messages(history)
+    except ValueError as e:
+        raise ValueError(f"Invalid conversation file {path}: {e}")
+    return history
+
+def trim_history(messages: List[Dict[str, str]], max_tokens: int) -> List[Dict[str, str]]:
+    """
+    Drop the oldest messages until a conversation fits a token budget.
+
+    System messages are always kept. So that the conversation still starts with
+    a user turn, as the API requires, an assistant reply left at the front is
+    dropped along with the message it answered.
+
+    Args:
+        messages (List[Dict[str, str]]): Conversation as role/content dicts
+        max_tokens (int): Budget as measured by estimate_tokens
+
+    Returns:
+        List[Dict[str, str]]: The trimmed conversation; may hold only system
+                              messages if nothing else fits
+    """
+    system = [message for message in messages if message.get("role") == "system"]
+    rest = [message for message in messages if message.get("role") != "system"]
+    while rest and estimate_tokens(system + rest) > max_tokens:
+        rest.pop(0)
+        while rest and rest[0].get("role") == "assistant":
+            rest.pop(0)
+    return system + rest
+
+def import_openai(file: TextIO) -> List[Dict[str, str]]:
+    """
+    Read a conversation in OpenAI's {"messages": [...]} format.
+
+    Only role and content are kept; "developer" messages become system messages.
+
+    Args:
+        file (TextIO): Open text file or stream holding the JSON
+
+    Returns:
+        List[Dict[str, str]]: Messages as role/content dicts
+
+    Raises:
+        ValueError: If the JSON is invalid or a message can't be represented
+    """
+    try:
+        data = json.load(file)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"Invalid OpenAI conversation: {e}")
+    if not isinstance(data, dict) or not isinstance(data.get("messages"), list):
+        raise ValueError('Invalid OpenAI conversation: expected an object with a "messages" list')
+    messages = []
+    for index, message in enumerate(data["messages"]):
+        if not isinstance(message, dict) or message.get("content") is None:
+            raise ValueError(f"Invalid OpenAI conversation: message {index} has no content")
+        role = message.get("role")
+        if role == _OPENAI_DEVELOPER_ROLE:
+            role = ROLE_SYSTEM
+        messages.append({"role": role, "content": message["content"]})
+    validate_messages(messages)
+    return messages
+
+def export_openai(file: TextIO, messages: List[Dict[str, str]]) -> None:
+    """
+    Write a conversation in OpenAI's {"messages": [...]} format.
+
+    Args:
+        file (TextIO): Open text file or stream to write the JSON to
+        messages (List[Dict[str, str]]): Messages as role/content dicts
+    """
+    validate_messages(messages)
+    json.dump({"messages": [{"role": message["role"], "content": message.get("content", "")}
+                            for message in messages]},
+              file, indent=2, ensure_ascii=False)
+    file.write("\n")
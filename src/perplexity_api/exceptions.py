@@ -0,0 +1,80 @@
+from typing import Dict, Optional
+
+class RequestCancelled(RuntimeError):
+    """Raised when a request is aborted through its cancel event."""
+
+class NetworkError(RuntimeError):
+    """Raised when the API can't be reached, e.g. DNS failure, refused connection or timeout."""
+
+class StreamInterruptedError(NetworkError):
+    """
+    Raised when a stream times out or its connection drops partway through an answer.
+
+    Attributes:
+        partial_response (Dict): Everything received before the failure, shaped like
+                                 a complete response; its content may be cut off
+                                 mid-sentence and its finish_reason is None
+    """
+    def __init__(self, message: str, partial_response: Dict):
+        super().__init__(message)
+        self.partial_response = partial_response
+
+class NoChoicesError(RuntimeError):
+    """
+    Raised when the API answers successfully but with no choices, e.g. because the
+    content was filtered.
+
+    Attributes:
+        response (Dict): The response as received
+        finish_reason (Optional[str]): Why generation stopped, if the API said
+    """
+    def __init__(self, response: Dict, finish_reason: Optional[str] = None):
+        message = "The API returned no answer"
+        if finish_reason:
+            message += f" (finish_reason: {finish_reason})"
+        super().__init__(message)
+        self.response = response
+        self.finish_reason = finish_reason
+
+class CacheMissError(RuntimeError):
+    """
+    Raised in offline mode when a request has no cached response, instead of calling the API.
+
+    Attributes:
+        key (Optional[str]): Cache key of the request, or None for requests that
+                             are never cached, such as streams and ping()
+    """
+    def __init__(self, key: Optional[str] = None):
+        if key:
+            message = f"No cached response for this request (offline mode, cache key {key[:12]})"
+        else:
+            message = "Offline mode: this kind of request is never cached, e.g. a stream"
+        super().__init__(message)
+        self.key = key
+
+class APIError(RuntimeError):
+    """
+    Raised when the API answers with a non-2xx status or a body that isn't JSON.
+
+    Attributes:
+        status_code (int): HTTP status code of the response
+        message (str): Error message from the response body, or a snippet of it
+    """
+    def __init__(self, status_code: int, message: str):
+        super().__init__(f"API request failed with status {status_code}: {message}")
+        self.status_code = status_code
+        self.message = message
+
+class RateLimitError(APIError):
+    """
+    Raised when the API keeps answering 429 after all retries are used up.
+
+    Attributes:
+        retry_after (Optional[float]): Seconds the server last asked us to wait, if given
+    """
+    def __init__(self, message: str, retry_after: Optional[float] = None):
+        super().__init__(429, message)
+        self.retry_after = retry_after
+
+class AuthenticationError(APIError):
+    """Raised when the API rejects the key with 401 or 403."""
@@ -0,0 +1,169 @@
+import os
+import re
+import textwrap
+from typing import Dict, List, Tuple
+
+# Inline citation markers such as [1] that the API inserts in answers
+_CITATION_MARKER = re.compile(r"\[(\d+)\]")
+
+# Leading indentation plus an optional bullet or numbered list marker
+_LINE_PREFIX = re.compile(r"\s*(?:[-*+]\s+|\d+[.)]\s+)?")
+
+def split_fenced(text: str) -> List[tuple]:
+    """
+    Split text into alternating prose and fenced code block segments.
+
+    Args:
+        text (str): Markdown text
+
+    Returns:
+        List[tuple]: (is_code, segment) pairs; code segments include their fences
+    """
+    segments = []
+    current: List[str] = []
+    in_code = False
+    fence = ""
+    for line in text.splitlines(keepends=True):
+        stripped = line.lstrip()
+        if not in_code and stripped.startswith(("```", "~~~")):
+            if current:
+                segments.append((False, "".join(current)))
+            current = [line]
+            in_code = True
+            fence = stripped[:3]
+        elif in_code and stripped.startswith(fence) and not stripped[3:].strip():
+            current.append(line)
+            segments.append((True, "".join(current)))
+            current = []
+            in_code = False
+        else:
+            current.append(line)
+    if current:
+        # An unterminated fence is still code
+        segments.append((in_code, "".join(current)))
+    return segments
+
+# File extensions for code blocks saved with extract_code_blocks, keyed by fence language
+CODE_EXTENSIONS = {
+    "python": "py", "py": "py", "javascript": "js", "js": "js", "typescript": "ts",
+    "ts": "ts", "go": "go", "rust": "rs", "java": "java", "c": "c", "cpp": "cpp",
+    "c++": "cpp", "csharp": "cs", "cs": "cs", "ruby": "rb", "php": "php",
+    "bash": "sh", "sh": "sh", "shell": "sh", "zsh": "sh", "sql": "sql", "html": "html",
+    "css": "css", "json": "json", "yaml": "yaml", "yml": "yaml", "toml": "toml",
+    "xml": "xml", "markdown": "md", "md": "md", "dockerfile": "dockerfile",
+}
+
+def code_block_parts(segment: str) -> Tuple[str, str, str, str]:
+    """
+    Take apart a fenced code block segment returned by split_fenced.
+
+    Args:
+        segment (str): Code block including its fences
+
+    Returns:
+        Tuple[str, str, str, str]: The opening fence line, the language from its info
+                                   string (empty if unlabeled), the code, and the closing
+                                   fence line (empty if the block is unterminated)
+    """
+    lines = segment.splitlines(keepends=True)
+    opening = lines[0]
+    fence = opening.strip()[:3]
+    closed = len(lines) > 1 and lines[-1].strip().startswith(fence) and not lines[-1].strip()[3:]
+    closing = lines[-1] if closed else ""
+    code = "".join(lines[1:-1] if closed else lines[1:])
+    info = opening.strip()[3:].strip()
+    language = info.split()[0].lower() if info else ""
+    return opening, language, code, closing
+
+def extract_code_blocks(text: str, directory: str) -> List[str]:
+    """
+    Save each fenced code block in a Markdown answer to its own file.
+
+    Files are numbered in order of appearance, e.g. block-1.py, block-2.txt; the
+    extension comes from the fence language, with .txt for unlabeled or unknown ones.
+
+    Args:
+        text (str): Markdown text
+        directory (str): Where to write the files; created if needed
+
+    Returns:
+        List[str]: Paths of the files written
+    """
+    paths = []
+    os.makedirs(directory, exist_ok=True)
+    for is_code, segment in split_fenced(text):
+        if not is_code:
+            continue
+        _, language, code, _ = code_block_parts(segment)
+        path = os.path.join(directory, f"block-{len(paths) + 1}.{CODE_EXTENSIONS.get(language, 'txt')}")
+        with open(path, "w", encoding="utf-8") as f:
+            f.write(code)
+        paths.append(path)
+    return paths
+
+def wrap_text(text: str, width: int) -> str:
+    """
+    Wrap prose to a column width, leaving fenced code blocks untouched.
+
+    Each line is wrapped on its own, so paragraphs and blank lines are kept;
+    continuation lines of list items are indented under the item text. Table
+    rows are left as they are.
+
+    Args:
+        text (str): Markdown text
+        width (int): Maximum line length
+
+    Returns:
+        str: The wrapped text
+    """
+    parts = []
+    for is_code, segment in split_fenced(text):
+        if is_code:
+            parts.append(segment)
+            continue
+        lines = []
+        for line in segment.splitlines(keepends=True):
+            body = line.rstrip("\r\n")
+            ending = line[len(body):]
+            if len(body) <= width or body.lstrip().startswith("|"):
+                lines.append(line)
+                continue
+            indent = " " * _LINE_PREFIX.match(body).end()
+            wrapped = textwrap.fill(body, width=width, subsequent_indent=indent,
+                                    break_long_words=False, break_on_hyphens=False)
+            lines.append(wrapped + ending)
+        parts.append("".join(lines))
+    return "".join(parts)
+
+def to_markdown(response: Dict) -> str:
+    """
+    Render a response as Markdown, turning citations into numbered footnotes.
+
+    Inline markers like [1] become footnote references outside code blocks;
+    code blocks are copied verbatim.
+
+    Args:
+        response (Dict): Parsed API response
+
+    Returns:
+        str: Markdown document
+    """
+    choices = response.get("choices") or []
+    content = choices[0].get("message", {}).get("content", "") if choices else ""
+    citations = response.get("citations") or []
+
+    def footnote(match):
+        number = int(match.group(1))
+        return f"[^{number}]" if 1 <= number <= len(citations) else match.group(0)
+
+    body = "".join(
+        segment if is_code else _CITATION_MARKER.sub(footnote, segment)
+        for is_code, segment in split_fenced(content)
+    )
+
+    lines = [body.rstrip("\n")]
+    if citations:
+        lines.append("")
+        for number, url in enumerate(citations, start=1):
+            lines.append(f"[^{number}]: <{url}>")
+    return "\n".join(lines) + "\n"
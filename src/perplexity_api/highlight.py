@@ -0,0 +1,49 @@
+from .export import code_block_parts, split_fenced
+
+try:
+    from pygments import highlight
+    from pygments.formatters import TerminalFormatter
+    from pygments.lexers import get_lexer_by_name, guess_lexer
+    from pygments.util import ClassNotFound
+except ImportError:  # Pygments is optional; see the color extra in setup.py
+    highlight = None
+
+# Fallback colour for code when Pygments isn't installed
+_CODE_COLOR = "\033[36m"
+_RESET = "\033[0m"
+
+def highlight_code_blocks(text: str) -> str:
+    """
+    Add ANSI colours to the fenced code blocks in a Markdown answer.
+
+    The language comes from the fence info string (```python); unlabeled blocks
+    are guessed. Without Pygments installed, code is shown in a single colour.
+    Prose is returned unchanged.
+
+    Args:
+        text (str): Markdown text
+
+    Returns:
+        str: The text with code blocks coloured for a terminal
+    """
+    parts = []
+    for is_code, segment in split_fenced(text):
+        if not is_code:
+            parts.append(segment)
+            continue
+        opening, language, code, closing = code_block_parts(segment)
+        parts.append(opening + _colorize(code, language) + closing)
+    return "".join(parts)
+
+def _colorize(code: str, language: str) -> str:
+    """Colour one block of code, keeping its trailing newline."""
+    if not code:
+        return code
+    if highlight is None:
+        return _CODE_COLOR + code.rstrip("\n") + _RESET + "\n"
+    try:
+        lexer = get_lexer_by_name(language) if language else guess_lexer(code)
+    except ClassNotFound:
+        return code
+    colored = highlight(code, lexer, TerminalFormatter())
+    return colored if code.endswith("\n") else colored.rstrip("\n")
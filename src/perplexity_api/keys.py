@@ -0,0 +1,66 @@
+import threading
+import time
+from typing import List, Tuple
+
+from cryptography.fernet import Fernet
+
+class KeyPool:
+    """
+    Thread-safe set of API keys, used in turn as their rate limits are hit.
+
+    Keys are kept encrypted in memory and only decrypted to build a request.
+    """
+    def __init__(self, keys: List[str]):
+        """
+        Initialize the pool, starting with the first key.
+
+        Args:
+            keys (List[str]): API keys to rotate between
+        """
+        if not keys:
+            raise ValueError("at least one API key is required")
+        self._fernet = Fernet(Fernet.generate_key())
+        self._keys = [self._fernet.encrypt(key.encode()) for key in keys]
+        self._available_at = [0.0] * len(keys)
+        self._current = 0
+        self._lock = threading.Lock()
+
+    def __len__(self) -> int:
+        return len(self._keys)
+
+    def current(self) -> Tuple[int, str]:
+        """
+        Pick the key to send the next request with.
+
+        Returns:
+            Tuple[int, str]: Index of the key in the pool and the key itself. If every
+                             key is cooling down, the one that recovers first is used.
+        """
+        with self._lock:
+            now = time.monotonic()
+            for offset in range(len(self._keys)):
+                index = (self._current + offset) % len(self._keys)
+                if self._available_at[index] <= now:
+                    break
+            else:
+                index = min(range(len(self._keys)), key=self._available_at.__getitem__)
+            self._current = index
+            return index, self._fernet.decrypt(self._keys[index]).decode()
+
+    def cool_down(self, index: int, seconds: float) -> bool:
+        """
+        Rest a key and move on to the next one.
+
+        Args:
+            index (int): Key as returned by current()
+            seconds (float): How long not to use it; float("inf") retires it for good
+
+        Returns:
+            bool: Whether another key is ready to use right now
+        """
+        with self._lock:
+            now = time.monotonic()
+            self._available_at[index] = max(self._available_at[index], now + seconds)
+            if self._current == index:
+                self._current = (index + 1) % len(self._keys)
+            return any(available <= now for available in self._available_at)
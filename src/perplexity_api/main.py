@@ -0,0 +1,966 @@
+import argparse
+import json
+import logging
+import math
+import os
+import shlex
+import shutil
+import signal
+import subprocess
+import sys
+import tempfile
+import threading
+import time
+from dataclasses import asdict
+from typing import Dict, List, Optional, TextIO
+
+from .client import (DEFAULT_SYSTEM_PROMPT, BatchResult, PerplexityAPI, RateLimitInfo,
+                     SessionStats, Usage, finish_reason, is_truncated)
+from .exceptions import AuthenticationError, NetworkError, RateLimitError, StreamInterruptedError
+from .config import load_config
+from .content import message_content
+from .conversation import load_conversation, save_conversation, trim_history
+from .export import extract_code_blocks, to_markdown, wrap_text
+from .highlight import highlight_code_blocks
+from .models import estimate_cost, get_model, list_models
+from .progress import Spinner
+from .sanitize import sanitize_input
+from .tokens import estimate_tokens
+from .template import parse_vars, render_prompt
+
+# Exit statuses, so scripts can tell failures apart
+EXIT_OK = 0
+EXIT_ERROR = 1
+EXIT_USAGE = 2  # Also what argparse uses for bad arguments
+EXIT_AUTH = 3
+EXIT_RATE_LIMITED = 4
+EXIT_NETWORK = 5
+EXIT_INTERRUPTED = 130  # 128 + SIGINT, as shells report it
+
+# Seconds between checks of the --watch file
+WATCH_INTERVAL = 0.5
+
+# Sent by --bench when no prompt is given, so runs are comparable across models
+BENCH_PROMPT = "Explain in about 200 words how a refrigerator keeps food cold."
+
+def exit_code_for(error: Exception) -> int:
+    """Map an error to the exit status reported for it."""
+    if isinstance(error, AuthenticationError):
+        return EXIT_AUTH
+    if isinstance(error, RateLimitError):
+        return EXIT_RATE_LIMITED
+    if isinstance(error, NetworkError):
+        return EXIT_NETWORK
+    if isinstance(error, (ValueError, TypeError)):
+        # Invalid settings, parameters, templates or files
+        return EXIT_USAGE
+    return EXIT_ERROR
+
+def install_signal_handlers(cancel: threading.Event) -> None:
+    """
+    Make SIGINT and SIGTERM set cancel and raise KeyboardInterrupt.
+
+    The event stops requests running on worker threads; the exception unwinds the
+    main thread, closing any open response on the way.
+
+    Args:
+        cancel (threading.Event): Passed to every request the CLI makes
+    """
+    def handle(signum, frame):
+        cancel.set()
+        raise KeyboardInterrupt
+
+    # Handlers can only be installed from the main thread, e.g. not when embedded
+    if threading.current_thread() is not threading.main_thread():
+        return
+    signal.signal(signal.SIGINT, handle)
+    if hasattr(signal, "SIGTERM"):
+        signal.signal(signal.SIGTERM, handle)
+
+def print_response(response: Dict, show_cost: bool = False, file: Optional[TextIO] = None,
+                   wrap: Optional[int] = None, color: bool = False, quiet: bool = False) -> None:
+    """
+    Print the assistant's answer followed by any sources, images, related questions and usage.
+
+    With quiet, only the answer text is printed.
+    """
+    choices = response.get('choices') or []
+    if not choices:
+        print("No response received", file=file)
+        return
+
+    def content(choice):
+        text = choice.get('message', {}).get('content', '')
+        if wrap:
+            text = wrap_text(text, wrap)
+        return highlight_code_blocks(text) if color else text
+
+    if len(choices) == 1:
+        print(content(choices[0]), file=file)
+    else:
+        for number, choice in enumerate(choices, start=1):
+            if number > 1:
+                print(file=file)
+            print(f"Choice {number}:", file=file)
+            print(content(choice), file=file)
+    if not quiet:
+        print_response_footer(response, show_cost=show_cost, file=file)
+
+def print_response_footer(response: Dict, show_cost: bool = False,
+                          file: Optional[TextIO] = None) -> None:
+    """Print what follows an answer: sources, images, related questions and usage."""
+    # Older models don't return citations at all
+    citations = response.get('citations')
+    if citations:
+        print("\nCitations:", file=file)
+        for number, url in enumerate(citations, start=1):
+            print(f"[{number}] {url}", file=file)
+
+    # Images arrive either as plain URLs or as objects describing each image
+    images = response.get('images')
+    if images:
+        print("\nImages:", file=file)
+        for image in images:
+            print(image.get('image_url', '') if isinstance(image, dict) else image, file=file)
+
+    related = response.get('related_questions')
+    if related:
+        print("\nRelated:", file=file)
+        for question in related:
+            print(f"- {question}", file=file)
+
+    usage = Usage.from_response(response)
+    if usage:
+        print(f"\nTokens: {usage.prompt_tokens} prompt + {usage.completion_tokens} completion"
+              f" = {usage.total_tokens} total", file=file)
+        model = response.get('model')
+        if show_cost and get_model(model):
+            print(f"Estimated cost: ${estimate_cost(usage, model):.6f}", file=file)
+
+def warn_if_truncated(response: Dict, quiet: bool = False) -> None:
+    """Tell stderr when max_tokens cut the answer off, unless quiet."""
+    if is_truncated(response) and not quiet:
+        print("Warning: the answer was cut off by max_tokens; raise --max-tokens for the rest.",
+              file=sys.stderr)
+
+def print_session_stats(stats: SessionStats, file: Optional[TextIO] = None) -> None:
+    """Print the totals for a session, if it sent anything."""
+    if not stats.requests:
+        return
+    print(f"Session: {stats.requests} request(s), {stats.prompt_tokens} prompt +"
+          f" {stats.completion_tokens} completion = {stats.total_tokens} tokens,"
+          f" estimated cost ${stats.cost:.6f}", file=file)
+
+def percentile(values: List[float], fraction: float) -> float:
+    """Return the nearest-rank percentile of a non-empty list, e.g. fraction=0.9 for p90."""
+    ordered = sorted(values)
+    rank = max(1, math.ceil(fraction * len(ordered)))
+    return ordered[rank - 1]
+
+def print_latency_summary(results: List[BatchResult], file: Optional[TextIO] = None) -> None:
+    """
+    Print the p50, p90 and p99 API latency of the answered requests, if any were sent.
+
+    Latency runs from sending the request to reading its answer; time spent
+    queued on the rate limiters or backing off between retries is shown apart.
+    """
+    latencies = [result.latency for result in results
+                 if result.error is None and result.latency is not None]
+    if not latencies:
+        return
+    queued = sum(result.queue_time for result in results) / len(results)
+    print(f"Latency: p50 {percentile(latencies, 0.5):.2f}s, p90 {percentile(latencies, 0.9):.2f}s,"
+          f" p99 {percentile(latencies, 0.99):.2f}s over {len(latencies)} answer(s);"
+          f" {queued:.2f}s average queue time", file=file)
+
+def print_rate_limit_status(info: Optional[RateLimitInfo], file: Optional[TextIO] = None) -> None:
+    """Print the remaining quota the API reported, if any."""
+    if info is None:
+        print("Rate limit: not reported by the API", file=file)
+        return
+    remaining = "?" if info.remaining is None else info.remaining
+    line = f"Rate limit: {remaining}"
+    if info.limit is not None:
+        line += f" of {info.limit}"
+    line += " requests left"
+    if info.model:
+        line += f" for {info.model}"
+    reset_in = info.reset_in()
+    if reset_in is not None:
+        line += f", resets in {reset_in:.0f}s"
+    print(line, file=file)
+
+def read_repl_input() -> str:
+    """
+    Read one REPL entry; a line ending in a backslash continues on the next line.
+
+    Returns:
+        str: The entry with continuation backslashes removed and lines joined by newlines
+
+    Raises:
+        EOFError, KeyboardInterrupt: As raised by input()
+    """
+    lines = []
+    line = input("> ")
+    while line.endswith("\\"):
+        lines.append(line[:-1])
+        line = input("... ")
+    lines.append(line)
+    return "\n".join(lines).strip()
+
+def edit_in_editor(text: str = "") -> str:
+    """
+    Let the user compose text in $VISUAL or $EDITOR (vi if neither is set).
+
+    Args:
+        text (str): Initial contents of the file being edited
+
+    Returns:
+        str: What the user saved, stripped of surrounding whitespace
+    """
+    editor = os.environ.get("VISUAL") or os.environ.get("EDITOR") or "vi"
+    fd, path = tempfile.mkstemp(prefix="pplx-", suffix=".md")
+    try:
+        with os.fdopen(fd, "w", encoding="utf-8") as f:
+            f.write(text)
+        # The editor setting may carry arguments, e.g. "code --wait"
+        subprocess.run(shlex.split(editor) + [path], check=True)
+        with open(path, encoding="utf-8") as f:
+            return f.read().strip()
+    finally:
+        os.remove(path)
+
+def run_repl(client: PerplexityAPI, model: str, show_cost: bool = False,
+             history: Optional[List[Dict[str, str]]] = None) -> None:
+    """
+    Run an interactive conversation until EOF or /quit, then print the session's totals.
+
+    Args:
+        client (PerplexityAPI): Client used to send each turn
+        model (str): Model to converse with
+        show_cost (bool): Print the estimated cost of each reply
+        history (Optional[List[Dict[str, str]]]): Earlier conversation to continue
+    """
+    history = list(history or [])
+    print("Interactive mode. End a line with \\ to continue it, or type /edit to write the"
+          " message in your editor. Type /regen [temperature] for a new answer to the last"
+          " question, /reset to clear the conversation, /save <path> or /load <path> to save"
+          " or restore it, /quit to exit.")
+    while True:
+        try:
+            line = read_repl_input()
+        except (EOFError, KeyboardInterrupt):
+            print()
+            break
+        if not line:
+            continue
+        if line == "/quit":
+            break
+        if line == "/edit":
+            try:
+                line = edit_in_editor()
+            except (OSError, subprocess.CalledProcessError) as e:
+                print(f"Error: could not run editor: {str(e)}", file=sys.stderr)
+                continue
+            if not line:
+                print("Empty message; nothing sent.")
+                continue
+        if line == "/reset":
+            history = []
+            print("Conversation cleared.")
+            continue
+        if line == "/save" or line.startswith("/save "):
+            path = line[len("/save"):].strip()
+            if not path:
+                print("Usage: /save <path>")
+                continue
+            if not history:
+                # An empty file couldn't be loaded again
+                print("Nothing to save yet; ask a question first.")
+                continue
+            try:
+                save_conversation(path, history)
+            except OSError as e:
+                print(f"Error: {str(e)}", file=sys.stderr)
+                continue
+            print(f"Saved {len(history)} messages to {path}")
+            continue
+        if line == "/load" or line.startswith("/load "):
+            path = line[len("/load"):].strip()
+            if not path:
+                print("Usage: /load <path>")
+                continue
+            try:
+                history = load_conversation(path)
+            except (OSError, ValueError) as e:
+                print(f"Error: {str(e)}", file=sys.stderr)
+                continue
+            print(f"Loaded {len(history)} messages from {path}")
+            continue
+
+        # The conversation the message is added to; /regen takes back the last exchange
+        base = history
+        params = {}
+        if line == "/regen" or line.startswith("/regen "):
+            argument = line[len("/regen"):].strip()
+            if argument:
+                try:
+                    params["temperature"] = float(argument)
+                except ValueError:
+                    print("Usage: /regen [temperature]")
+                    continue
+            if (len(history) < 2 or history[-1].get("role") != "assistant"
+                    or history[-2].get("role") != "user"):
+                print("Nothing to regenerate yet; ask a question first.")
+                continue
+            line = history[-2]["content"]
+            base = history[:-2]
+
+        # Leave room for the new message and the answer so long sessions keep working
+        info = get_model(model)
+        if info:
+            budget = (info.context_window - (client.config.max_tokens or 0)
+                      - estimate_tokens([{"role": "user", "content": line}]))
+            trimmed = trim_history(base, budget)
+            if len(trimmed) < len(base):
+                print(f"Dropped {len(base) - len(trimmed)} old messages to fit the context window.",
+                      file=sys.stderr)
+                base = trimmed
+        try:
+            with Spinner(enabled=sys.stdout.isatty()):
+                response, history = client.continue_conversation(
+                    base, line, system_prompt=DEFAULT_SYSTEM_PROMPT, model=model, **params
+                )
+        except KeyboardInterrupt:
+            # Ctrl+C abandons the turn, not the session
+            print("\nCancelled.", file=sys.stderr)
+            continue
+        except (RuntimeError, ValueError) as e:
+            # Keep the session alive; the failed turn is simply not recorded
+            print(f"Error: {str(e)}", file=sys.stderr)
+            continue
+        print_response(response, show_cost=show_cost)
+        warn_if_truncated(response)
+        print()
+    print_session_stats(client.session_stats())
+
+def answer_prompt(client: PerplexityAPI, prompt: str, args: argparse.Namespace,
+                  history: Optional[List[Dict[str, str]]], cancel: threading.Event) -> None:
+    """
+    Send one prompt and write the answer as the command-line flags ask.
+
+    Args:
+        client (PerplexityAPI): Client to send the prompt with
+        prompt (str): The rendered prompt
+        args (argparse.Namespace): Parsed command-line arguments
+        history (Optional[List[Dict[str, str]]]): Earlier conversation to continue
+        cancel (threading.Event): Aborts the request when set
+    """
+    # Read any images first so a bad path fails before the output file is replaced
+    content = message_content(prompt, args.image)
+    # Only someone watching a terminal needs reassurance; JSON output is for programs
+    spinner = Spinner(enabled=sys.stdout.isatty() and not args.quiet and args.format != "json")
+    out = open(args.output, "w", encoding="utf-8") if args.output else sys.stdout
+    try:
+        streamed = False
+        if history is not None:
+            with spinner:
+                response, _ = client.continue_conversation(history, content,
+                                                           system_prompt=DEFAULT_SYSTEM_PROMPT,
+                                                           cancel=cancel)
+        elif args.stream and args.format == "text":
+            try:
+                response = client.stream_to([{"role": "user", "content": content}], out,
+                                            system_prompt=DEFAULT_SYSTEM_PROMPT, cancel=cancel)
+            except StreamInterruptedError:
+                # Keep the partial answer and end its line before the error is reported
+                print(file=out)
+                raise
+            print(file=out)  # Add newline at the end
+            if not args.quiet:
+                print_response_footer(response, show_cost=args.cost, file=out)
+            streamed = True
+        else:
+            with spinner:
+                response = client.chat([{"role": "user", "content": content}],
+                                       system_prompt=DEFAULT_SYSTEM_PROMPT, cancel=cancel)
+        if streamed:
+            pass  # Already written as it arrived
+        elif args.format == "json":
+            print(json.dumps(response, indent=2), file=out)
+        elif args.format == "markdown":
+            print(to_markdown(response), end='', file=out)
+        else:
+            print_response(response, show_cost=args.cost, file=out, wrap=wrap_width(args.wrap),
+                           color=args.color and out.isatty(), quiet=args.quiet)
+        warn_if_truncated(response, quiet=args.quiet)
+        if args.extract:
+            choices = response.get('choices') or []
+            content = choices[0].get('message', {}).get('content', '') if choices else ''
+            for path in extract_code_blocks(content, args.extract):
+                print(f"Wrote {path}", file=sys.stderr)
+    finally:
+        if out is not sys.stdout:
+            out.close()
+
+def read_batch_file(path: str) -> List[str]:
+    """
+    Read one prompt per line, skipping blank lines.
+
+    Args:
+        path (str): File of prompts, or "-" for stdin
+
+    Returns:
+        List[str]: The prompts with surrounding whitespace removed
+    """
+    if path == "-":
+        lines = sys.stdin.read().splitlines()
+    else:
+        with open(path, encoding="utf-8") as f:
+            lines = f.read().splitlines()
+    return [line.strip() for line in lines if line.strip()]
+
+def batch_record(index: int, prompt: str, result: BatchResult) -> Dict:
+    """Describe one batch result as a JSON Lines record."""
+    response = result.response or {}
+    choices = response.get('choices') or []
+    usage = Usage.from_response(response)
+    return {
+        "index": index,
+        "prompt": prompt,
+        "answer": choices[0].get('message', {}).get('content') if choices else None,
+        "finish_reason": finish_reason(response),
+        "usage": asdict(usage) if usage else None,
+        "latency": round(result.latency, 3) if result.latency is not None else None,
+        "queue_time": round(result.queue_time, 3),
+        "error": str(result.error) if result.error is not None else None
+    }
+
+def run_batch(client: PerplexityAPI, prompts: List[str], args: argparse.Namespace,
+              cancel: threading.Event) -> int:
+    """
+    Answer every prompt, pairing each answer with its prompt in the output.
+
+    With --format json each result is written as one JSON line as soon as it
+    arrives, so lines come in completion order and carry the prompt's index;
+    otherwise prompts and answers are printed in input order. The session's
+    totals and the latency percentiles of the answers go to stderr at the end.
+
+    Args:
+        client (PerplexityAPI): Client to send the prompts with
+        prompts (List[str]): Prompts to answer
+        args (argparse.Namespace): Parsed command-line arguments
+        cancel (threading.Event): Aborts outstanding requests when set
+
+    Returns:
+        int: EXIT_OK if every prompt was answered, else the exit status for the first failure
+    """
+    status = EXIT_OK
+    collected: List[BatchResult] = []
+    out = open(args.output, "w", encoding="utf-8") if args.output else sys.stdout
+    try:
+        if args.format == "json":
+            for index, result in client.iter_query_batch(prompts, system_prompt=DEFAULT_SYSTEM_PROMPT,
+                                                         concurrency=args.concurrency,
+                                                         cancel=cancel):
+                collected.append(result)
+                if result.error is not None and status == EXIT_OK:
+                    status = exit_code_for(result.error)
+                print(json.dumps(batch_record(index, prompts[index], result)), file=out, flush=True)
+            return status
+
+        results = client.query_batch(prompts, system_prompt=DEFAULT_SYSTEM_PROMPT,
+                                     concurrency=args.concurrency, cancel=cancel)
+        for number, (prompt, result) in enumerate(zip(prompts, results), start=1):
+            collected.append(result)
+            if result.error is not None and status == EXIT_OK:
+                status = exit_code_for(result.error)
+            if number > 1:
+                print(file=out)
+            print(f"Prompt: {prompt}", file=out)
+            if result.error is not None:
+                print(f"Error: {str(result.error)}", file=out)
+            elif args.format == "markdown":
+                print(to_markdown(result.response), end='', file=out)
+            else:
+                print_response(result.response, show_cost=args.cost, file=out,
+                               wrap=wrap_width(args.wrap), color=args.color and out.isatty(),
+                               quiet=args.quiet)
+            if result.error is None and is_truncated(result.response) and not args.quiet:
+                print(f"Warning: the answer to prompt {number} was cut off by max_tokens.",
+                      file=sys.stderr)
+    finally:
+        if out is not sys.stdout:
+            out.close()
+        if not args.quiet:
+            print_session_stats(client.session_stats(), file=sys.stderr)
+            print_latency_summary(collected, file=sys.stderr)
+    return status
+
+def compare_record(model: str, result: BatchResult) -> Dict:
+    """Describe one model's answer in --compare as a JSON object."""
+    record = batch_record(0, "", result)
+    del record["index"], record["prompt"]
+    return dict(model=model, **record)
+
+def run_compare(client: PerplexityAPI, prompt: str, models: List[str], args: argparse.Namespace,
+                cancel: threading.Event) -> int:
+    """
+    Ask several models the same prompt at once and print their answers together.
+
+    Each answer is printed under its model's name with how long it took; a
+    failing model gets its error in its section without stopping the others.
+    The session's totals go to stderr at the end.
+
+    Args:
+        client (PerplexityAPI): Client to send the prompt with
+        prompt (str): The rendered prompt
+        models (List[str]): Models to compare, in the order to print them
+        args (argparse.Namespace): Parsed command-line arguments
+        cancel (threading.Event): Aborts outstanding requests when set
+
+    Returns:
+        int: EXIT_OK if every model answered, else the exit status for the first failure
+    """
+    messages = [{"role": "user", "content": message_content(prompt, args.image)}]
+    spinner = Spinner(enabled=sys.stdout.isatty() and not args.quiet and args.format != "json")
+    with spinner:
+        results = client.compare(messages, models, concurrency=min(args.concurrency, len(models)),
+                                 system_prompt=DEFAULT_SYSTEM_PROMPT, cancel=cancel)
+    status = EXIT_OK
+    for result in results:
+        if result.error is not None and status == EXIT_OK:
+            status = exit_code_for(result.error)
+    out = open(args.output, "w", encoding="utf-8") if args.output else sys.stdout
+    try:
+        if args.format == "json":
+            print(json.dumps([compare_record(model, result)
+                              for model, result in zip(models, results)], indent=2), file=out)
+            return status
+
+        for number, (model, result) in enumerate(zip(models, results), start=1):
+            if number > 1:
+                print(file=out)
+            timing = "cached" if result.latency is None else f"{result.latency:.2f}s"
+            if args.format == "markdown":
+                print(f"## {model} ({timing})\n", file=out)
+            else:
+                print(f"=== {model} ({timing}) ===", file=out)
+            if result.error is not None:
+                print(f"Error: {str(result.error)}", file=out)
+            elif args.format == "markdown":
+                print(to_markdown(result.response), end='', file=out)
+            else:
+                print_response(result.response, show_cost=args.cost, file=out,
+                               wrap=wrap_width(args.wrap), color=args.color and out.isatty(),
+                               quiet=args.quiet)
+            if result.error is None and is_truncated(result.response) and not args.quiet:
+                print(f"Warning: the answer from {model} was cut off by max_tokens.",
+                      file=sys.stderr)
+    finally:
+        if out is not sys.stdout:
+            out.close()
+        if not args.quiet:
+            print_session_stats(client.session_stats(), file=sys.stderr)
+    return status
+
+def run_bench(client: PerplexityAPI, prompt: str, runs: int, args: argparse.Namespace,
+              cancel: threading.Event) -> int:
+    """
+    Send one prompt repeatedly and print a summary of latency, throughput and cost.
+
+    Requests run --concurrency at a time within the client's rate limits.
+    Latency is timed from sending each request, so waits on the rate limiters
+    and between retries are reported as queue time instead; failed runs are
+    counted but left out of the latency figures.
+
+    Args:
+        client (PerplexityAPI): Client to send the prompt with; its cache should be off
+        prompt (str): The prompt to send on every run
+        runs (int): How many times to send it
+        args (argparse.Namespace): Parsed command-line arguments
+        cancel (threading.Event): Aborts outstanding requests when set
+
+    Returns:
+        int: EXIT_OK if every run succeeded, else the exit status for the first failure
+    """
+    spinner = Spinner(f"Running {runs} requests", enabled=sys.stderr.isatty() and not args.quiet)
+    start = time.monotonic()
+    with spinner:
+        results = client.query_batch([prompt] * runs, system_prompt=DEFAULT_SYSTEM_PROMPT,
+                                     concurrency=args.concurrency, cancel=cancel)
+    elapsed = time.monotonic() - start
+    stats = client.session_stats()
+
+    status = EXIT_OK
+    latencies = []
+    for result in results:
+        if result.error is None:
+            if result.latency is not None:
+                latencies.append(result.latency)
+        elif status == EXIT_OK:
+            status = exit_code_for(result.error)
+            print(f"Error: {str(result.error)}", file=sys.stderr)
+    failed = sum(1 for result in results if result.error is not None)
+    summary = {
+        "model": client.config.model,
+        "runs": runs,
+        "failed": failed,
+        "seconds": round(elapsed, 3),
+        "avg_latency": round(sum(latencies) / len(latencies), 3) if latencies else None,
+        "p50_latency": round(percentile(latencies, 0.5), 3) if latencies else None,
+        "p90_latency": round(percentile(latencies, 0.9), 3) if latencies else None,
+        "avg_queue_time": round(sum(result.queue_time for result in results) / runs, 3),
+        "completion_tokens": stats.completion_tokens,
+        "tokens_per_second": round(stats.completion_tokens / elapsed, 1) if elapsed else None,
+        "total_tokens": stats.total_tokens,
+        "cost": round(stats.cost, 6)
+    }
+    if args.format == "json":
+        print(json.dumps(summary, indent=2))
+        return status
+
+    def seconds(value):
+        return "-" if value is None else f"{value:.2f}s"
+
+    rows = [
+        ("Model", summary["model"]),
+        ("Runs", f"{runs} ({summary['failed']} failed), concurrency {args.concurrency}"),
+        ("Wall time", seconds(summary["seconds"])),
+        ("Avg latency", seconds(summary["avg_latency"])),
+        ("p50 / p90", f"{seconds(summary['p50_latency'])} / {seconds(summary['p90_latency'])}"),
+        ("Avg queue time", seconds(summary["avg_queue_time"])),
+        ("Tokens/sec", "-" if summary["tokens_per_second"] is None
+                       else f"{summary['tokens_per_second']:.1f} (completion)"),
+        ("Total tokens", str(stats.total_tokens)),
+        ("Total cost", f"${stats.cost:.6f}"),
+    ]
+    width = max(len(label) for label, _ in rows)
+    for label, value in rows:
+        print(f"{label:<{width}}  {value}")
+    return status
+
+def run_watch(client: PerplexityAPI, path: str, args: argparse.Namespace,
+              history: Optional[List[Dict[str, str]]], cancel: threading.Event) -> int:
+    """
+    Answer the prompt in a file, then again every time the file changes, until Ctrl+C.
+
+    Args:
+        client (PerplexityAPI): Client to send the prompt with
+        path (str): File holding the prompt
+        args (argparse.Namespace): Parsed command-line arguments
+        history (Optional[List[Dict[str, str]]]): Earlier conversation each run continues
+        cancel (threading.Event): Aborts the request when set
+
+    Returns:
+        int: EXIT_INTERRUPTED once Ctrl+C or SIGTERM stops the watch
+    """
+    os.stat(path)  # Fail now on a mistyped path rather than waiting forever
+    last_seen = None
+    try:
+        while True:
+            try:
+                stat = os.stat(path)
+                seen = (stat.st_mtime_ns, stat.st_size)
+            except FileNotFoundError:
+                seen = None  # Some editors replace the file when saving
+            if seen is not None and seen != last_seen:
+                last_seen = seen
+                if sys.stdout.isatty():
+                    print("\033[2J\033[H", end="")  # Clear the screen
+                try:
+                    with open(path, encoding="utf-8") as f:
+                        prompt = f.read().strip()
+                    if args.var:
+                        prompt = render_prompt(prompt, parse_vars(args.var))
+                    if prompt:
+                        answer_prompt(client, prompt, args, history, cancel)
+                except (OSError, ValueError, RuntimeError) as e:
+                    print(f"Error: {str(e)}", file=sys.stderr)
+                print(f"\nWatching {path} for changes; press Ctrl+C to stop.", file=sys.stderr)
+            time.sleep(WATCH_INTERVAL)
+    except KeyboardInterrupt:
+        print()
+        return EXIT_INTERRUPTED
+
+def read_prompt(quiet: bool = False) -> str:
+    """
+    Read the prompt from piped stdin, or ask for it on an interactive terminal.
+
+    Args:
+        quiet (bool): Read from the terminal without printing a question first
+
+    Returns:
+        str: The prompt text with surrounding whitespace removed
+    """
+    if sys.stdin.isatty():
+        return input("" if quiet else "Enter your question: ").strip()
+    return sys.stdin.read().strip()
+
+def choose_model(default: str) -> str:
+    """
+    Ask the user to pick a model from the registry.
+
+    Args:
+        default (str): Model used when the user just presses Enter
+
+    Returns:
+        str: The chosen model name
+    """
+    models = list_models(include_deprecated=False)
+    print("Available models:")
+    for number, model in enumerate(models, start=1):
+        marker = " (default)" if model.name == default else ""
+        print(f"{number}. {model.name}{marker}")
+    choice = input("Choose a model: ").strip()
+    if not choice:
+        return default
+    if not choice.isdigit() or not 1 <= int(choice) <= len(models):
+        raise ValueError(f"Invalid model choice: {choice}")
+    return models[int(choice) - 1].name
+
+def parse_headers(values: List[str]) -> Dict[str, str]:
+    """Parse "Name: value" strings from --header into a dict."""
+    headers = {}
+    for value in values:
+        name, sep, content = value.partition(":")
+        if not sep or not name.strip():
+            raise ValueError(f"Headers must look like 'Name: value', got {value!r}")
+        headers[name.strip()] = content.strip()
+    return headers
+
+def wrap_width(cols: Optional[int]) -> Optional[int]:
+    """Resolve the --wrap value: None disables wrapping, 0 means the terminal width."""
+    if cols is None:
+        return None
+    return cols or shutil.get_terminal_size((80, 24)).columns
+
+def parse_args(argv: Optional[List[str]] = None) -> argparse.Namespace:
+    """Parse command-line arguments."""
+    parser = argparse.ArgumentParser(description="Query the Perplexity AI chat completions API.")
+    parser.add_argument("prompt", nargs="*",
+                        help="question to ask; read from stdin when omitted")
+    parser.add_argument("-m", "-model", "--model",
+                        help="model to use; prompts for one on a terminal when omitted")
+    parser.add_argument("-t", "--temperature", type=float,
+                        help="sampling temperature")
+    parser.add_argument("--max-tokens", type=int,
+                        help="maximum tokens in the answer")
+    parser.add_argument("-n", type=int,
+                        help="number of alternative answers to generate")
+    parser.add_argument("--var", action="append", default=[], metavar="KEY=VALUE",
+                        help="treat the prompt as a template and set $KEY to VALUE; repeatable")
+    parser.add_argument("-image", "--image", action="append", default=[], metavar="PATH_OR_URL",
+                        help="attach an image file or URL to the prompt; may be repeated")
+    parser.add_argument("--api-key",
+                        help="API key; overrides PPLX_API_KEY and .env (visible in the process list)")
+    parser.add_argument("--ca-cert", metavar="PEM",
+                        help="verify the API's TLS certificate against this CA bundle,"
+                             " e.g. for a corporate proxy")
+    parser.add_argument("-H", "--header", action="append", default=[], metavar="'NAME: VALUE'",
+                        help="extra HTTP header to send with every request; repeatable")
+    parser.add_argument("--cache", metavar="DIR",
+                        help="cache responses in DIR and reuse them for identical requests")
+    parser.add_argument("--cache-ttl", type=float, default=3600,
+                        help="seconds a cached response stays valid (default: 3600)")
+    parser.add_argument("-offline", "--offline", action="store_true",
+                        help="answer only from --cache and fail on a miss instead of calling"
+                             " the API; turns off --stream")
+    parser.add_argument("--config",
+                        help="settings file to load (default: ~/.config/pplx/config.json)")
+    parser.add_argument("-load", "--load", metavar="PATH",
+                        help="continue a conversation saved with /save")
+    parser.add_argument("-watch", "--watch", metavar="FILE",
+                        help="answer the prompt in FILE and again whenever it changes")
+    parser.add_argument("-batch", "--batch", metavar="FILE",
+                        help="answer each non-empty line of FILE (- for stdin) as a separate prompt;"
+                             " with --json, print one JSON line per result as it completes")
+    parser.add_argument("-bench", "--bench", type=int, metavar="N",
+                        help="send the prompt (or a built-in one) N times, bypassing the cache,"
+                             " and print latency, tokens/sec and cost")
+    parser.add_argument("-concurrency", "--concurrency", type=int, default=4, metavar="N",
+                        help="requests --batch or --bench runs at once, within the rate limit;"
+                             " 1 answers prompts one after another (default: 4)")
+    parser.add_argument("-compare", "--compare", metavar="MODEL,MODEL",
+                        help="ask each of these models the prompt at once and print their"
+                             " answers together, with latency and usage")
+    parser.add_argument("-i", "--interactive", action="store_true",
+                        help="start an interactive conversation")
+    parser.add_argument("-s", "--stream", action="store_true",
+                        help="print the answer as it is generated")
+    parser.add_argument("--raw", action="store_true",
+                        help="send prompts verbatim without stripping control characters")
+    parser.add_argument("--cost", action="store_true",
+                        help="print the estimated cost of each answer")
+    parser.add_argument("-quota", "--quota", action="store_true",
+                        help="print the API's remaining rate limit quota to stderr when done")
+    parser.add_argument("-dry-run", "--dry-run", action="store_true",
+                        help="print the request that would be sent (API key redacted) and exit")
+    parser.add_argument("-q", "-quiet", "--quiet", action="store_true",
+                        help="print only the answer: no model menu, citations, usage or warnings")
+    parser.add_argument("-v", "--verbose", action="store_true",
+                        help="log request details to stderr")
+    parser.add_argument("-debug", "--debug", action="store_true",
+                        help="log raw request payloads and response bodies (API key redacted)")
+    parser.add_argument("-wrap", "--wrap", type=int, metavar="COLS",
+                        help="wrap the answer at COLS columns, 0 for the terminal width;"
+                             " code blocks are never wrapped")
+    parser.add_argument("-color", "--color", action="store_true",
+                        help="syntax-highlight code blocks; ignored unless writing to a terminal")
+    parser.add_argument("-extract", "--extract", metavar="DIR",
+                        help="also save each code block in the answer to a numbered file in DIR")
+    parser.add_argument("-o", "-output", "--output", metavar="PATH",
+                        help="write the answer to PATH instead of stdout, replacing its contents")
+    parser.add_argument("-format", "--format", choices=("text", "json", "markdown"), default="text",
+                        help="output format (default: text)")
+    parser.add_argument("-json", "--json", action="store_const", const="json", dest="format",
+                        help="print the full response as JSON; same as --format json")
+    args = parser.parse_args(argv)
+    if args.wrap is not None and args.wrap < 0:
+        parser.error("--wrap must not be negative")
+    if args.concurrency < 1:
+        parser.error("--concurrency must be at least 1")
+    if args.bench is not None and args.bench < 1:
+        parser.error("--bench must be at least 1")
+    if args.offline:
+        if not args.cache:
+            parser.error("--offline needs --cache DIR to answer from")
+        if args.bench:
+            parser.error("--bench always calls the API, so it can't run --offline")
+        args.stream = False  # Streams are never cached
+    if args.compare is not None:
+        args.compare = [name.strip() for name in args.compare.split(",") if name.strip()]
+        if len(args.compare) < 2:
+            parser.error("--compare needs at least two models, separated by commas")
+        for name in args.compare:
+            if get_model(name) is None:
+                parser.error(f"unknown model {name!r} in --compare")
+    if args.model and get_model(args.model) is None:
+        parser.error(f"unknown model {args.model!r}; choose from: "
+                     + ", ".join(model.name for model in list_models(include_deprecated=False)))
+    return args
+
+def main(argv: Optional[List[str]] = None) -> int:
+    """
+    Run the Perplexity command-line interface.
+
+    Errors are reported on stderr; this is the only place that decides the process exit status.
+
+    Returns:
+        int: Exit status: EXIT_OK on success, otherwise the EXIT_* code for the error
+    """
+    args = parse_args(argv)
+    # Logs go to stderr so stdout only ever carries the answer
+    logging.basicConfig(
+        stream=sys.stderr,
+        level=logging.WARNING,
+        format="%(asctime)s %(levelname)s %(name)s: %(message)s"
+    )
+    if args.verbose or args.debug:
+        logging.getLogger("perplexity_api").setLevel(logging.DEBUG)
+    elif args.quiet:
+        logging.getLogger("perplexity_api").setLevel(logging.ERROR)
+    cancel = threading.Event()
+    install_signal_handlers(cancel)
+    try:
+        # Initialize API client
+        # Flags override the config file, which overrides built-in defaults
+        settings = load_config(args.config)
+        client = PerplexityAPI(
+            api_key=args.api_key,
+            debug=args.debug,
+            # A benchmark answered from the cache would measure nothing
+            cache_dir=None if args.bench else args.cache,
+            cache_ttl=args.cache_ttl,
+            sanitizer=None if args.raw else sanitize_input,
+            headers=parse_headers(args.header),
+            ca_bundle=args.ca_cert,
+            offline=args.offline,
+            **settings
+        )
+        if args.temperature is not None:
+            client.config.temperature = args.temperature
+        if args.max_tokens is not None:
+            client.config.max_tokens = args.max_tokens
+        if args.n is not None:
+            client.config.n = args.n
+
+        # Only offer the chooser when someone is there to answer it
+        if args.model:
+            client.config.model = args.model
+        elif "model" not in settings and sys.stdin.isatty() and not args.quiet and not args.compare:
+            client.config.model = choose_model(client.config.model)
+
+        history = load_conversation(args.load) if args.load else None
+
+        if args.bench:
+            client.config.deduplicate_requests = False
+            prompt = " ".join(args.prompt).strip() or BENCH_PROMPT
+            if args.var:
+                prompt = render_prompt(prompt, parse_vars(args.var))
+            status = run_bench(client, prompt, args.bench, args, cancel)
+            if args.quota:
+                print_rate_limit_status(client.rate_limit_status(), file=sys.stderr)
+            return status
+
+        if args.interactive:
+            run_repl(client, client.config.model, show_cost=args.cost, history=history)
+            if args.quota:
+                print_rate_limit_status(client.rate_limit_status(), file=sys.stderr)
+            return EXIT_OK
+        if args.watch:
+            return run_watch(client, args.watch, args, history, cancel)
+        if args.batch:
+            status = run_batch(client, read_batch_file(args.batch), args, cancel)
+            if args.quota:
+                print_rate_limit_status(client.rate_limit_status(), file=sys.stderr)
+            return status
+
+        # A prompt on the command line wins over anything piped in
+        prompt = " ".join(args.prompt).strip() or read_prompt(quiet=args.quiet)
+        if not prompt:
+            print("Error: no prompt given", file=sys.stderr)
+            return EXIT_USAGE
+        if args.var:
+            prompt = render_prompt(prompt, parse_vars(args.var))
+
+        if args.dry_run:
+            messages = list(history or []) + [{"role": "user",
+                                               "content": message_content(prompt, args.image)}]
+            has_system = any(message.get("role") == "system" for message in messages)
+            if args.compare:
+                previews = [client.build_request(messages, system_prompt=DEFAULT_SYSTEM_PROMPT,
+                                                 model=model) for model in args.compare]
+                print(json.dumps(previews, indent=2))
+                return EXIT_OK
+            request = client.build_request(
+                messages,
+                system_prompt=None if has_system else DEFAULT_SYSTEM_PROMPT,
+                stream=args.stream and history is None and args.format == "text"
+            )
+            print(json.dumps(request, indent=2))
+            return EXIT_OK
+
+        if args.compare:
+            status = run_compare(client, prompt, args.compare, args, cancel)
+            if args.quota:
+                print_rate_limit_status(client.rate_limit_status(), file=sys.stderr)
+            return status
+
+        answer_prompt(client, prompt, args, history, cancel)
+        if args.quota:
+            print_rate_limit_status(client.rate_limit_status(), file=sys.stderr)
+
+    except KeyboardInterrupt:
+        # Whatever was streamed is already flushed; end its line before exiting
+        if args.stream and not args.output:
+            print()
+        print("Interrupted", file=sys.stderr)
+        return EXIT_INTERRUPTED
+    except Exception as e:
+        print(f"Error: {str(e)}", file=sys.stderr)
+        return exit_code_for(e)
+    return EXIT_OK
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,41 @@
+class Metrics:
+    """
+    Hooks the client calls to report on its requests; every method does nothing.
+
+    Subclass it and override the methods you need to feed a monitoring system,
+    e.g. Prometheus counters and histograms labelled by model:
+
+        class PrometheusMetrics(Metrics):
+            def observe_latency(self, model, seconds):
+                LATENCY.labels(model=model).observe(seconds)
+
+        client = PerplexityAPI(metrics=PrometheusMetrics())
+
+    Methods are called from whichever thread sent the request, so implementations
+    must be thread-safe.
+    """
+    def inc_request(self, model: str, status_code: int) -> None:
+        """Count one HTTP attempt that got a response, whatever its status."""
+
+    def observe_latency(self, model: str, seconds: float) -> None:
+        """Record how long one HTTP attempt took to return its response headers."""
+
+    def observe_duration(self, model: str, seconds: float) -> None:
+        """
+        Record how long one chat() or stream_to() call took from start to finish.
+
+        Unlike observe_latency this covers the whole call: rate limit waits,
+        retries, fallback models and, for streams, reading the full answer.
+        model is the one requested; failed calls are recorded too.
+        """
+
+    def inc_retry(self, model: str) -> None:
+        """Count one retry, after a network error, 429 or 5xx."""
+
+    def inc_error(self, model: str, kind: str) -> None:
+        """
+        Count one request that failed after any retries.
+
+        kind is "network" when the API couldn't be reached, otherwise the HTTP
+        status code as a string, e.g. "429".
+        """
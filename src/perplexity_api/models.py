@@ -0,0 +1,103 @@
+from dataclasses import dataclass
+from typing import TYPE_CHECKING, List, Optional
+
+if TYPE_CHECKING:
+    from .client import Usage
+
+@dataclass(frozen=True)
+class ModelInfo:
+    """
+    Description of a model served by the Perplexity API.
+
+    Attributes:
+        name (str): Model identifier sent in requests
+        context_window (int): Maximum tokens in prompt plus completion
+        online (bool): Whether the model searches the web to answer
+        input_price (float): USD per million prompt tokens
+        output_price (float): USD per million completion tokens
+        request_price (float): USD charged per request, on top of token prices
+        deprecated (bool): Whether Perplexity is retiring the model; still accepted, but
+                           requests using it log a warning
+        replaced_by (Optional[str]): Model suggested in that warning instead
+        rate_limit (Optional[float]): Requests per second the API allows for this model;
+                                      None if only the client-wide limit applies
+    """
+    name: str
+    context_window: int
+    online: bool
+    input_price: float = 0.0
+    output_price: float = 0.0
+    request_price: float = 0.0
+    deprecated: bool = False
+    replaced_by: Optional[str] = None
+    rate_limit: Optional[float] = None
+
+# Per-model limits as published in requests per minute, converted to per second
+_RPM_50 = 50 / 60
+_RPM_10 = 10 / 60
+_RPM_5 = 5 / 60
+
+# Known models, their prices and rate limits; add or update a line here to change them everywhere
+MODELS: List[ModelInfo] = [
+    ModelInfo("sonar", 127072, True, 1.0, 1.0, 0.005, rate_limit=_RPM_50),
+    ModelInfo("sonar-pro", 200000, True, 3.0, 15.0, 0.005, rate_limit=_RPM_50),
+    ModelInfo("sonar-reasoning", 127072, True, 1.0, 5.0, 0.005, rate_limit=_RPM_50),
+    ModelInfo("sonar-reasoning-pro", 127072, True, 2.0, 8.0, 0.005, rate_limit=_RPM_50),
+    ModelInfo("sonar-deep-research", 127072, True, 2.0, 8.0, 0.005, rate_limit=_RPM_5),
+    ModelInfo("r1-1776", 127072, False, 2.0, 8.0, rate_limit=_RPM_50),
+    # The llama-3.1 names are being retired in favour of the sonar family
+    ModelInfo("llama-3.1-sonar-small-128k-online", 127072, True, 0.2, 0.2, 0.005, deprecated=True,
+              replaced_by="sonar", rate_limit=_RPM_50),
+    ModelInfo("llama-3.1-sonar-large-128k-online", 127072, True, 1.0, 1.0, 0.005, deprecated=True,
+              replaced_by="sonar-pro", rate_limit=_RPM_50),
+    ModelInfo("llama-3.1-sonar-huge-128k-online", 127072, True, 5.0, 5.0, 0.005, deprecated=True,
+              replaced_by="sonar-pro", rate_limit=_RPM_10),
+    ModelInfo("llama-3.1-sonar-small-128k-chat", 127072, False, 0.2, 0.2, deprecated=True,
+              replaced_by="r1-1776", rate_limit=_RPM_50),
+    ModelInfo("llama-3.1-sonar-large-128k-chat", 127072, False, 1.0, 1.0, deprecated=True,
+              replaced_by="r1-1776", rate_limit=_RPM_50),
+]
+
+def list_models(include_deprecated: bool = True) -> List[ModelInfo]:
+    """
+    Return the known models.
+
+    Args:
+        include_deprecated (bool): Also return models that are being retired
+
+    Returns:
+        List[ModelInfo]: Models in registry order, current ones first
+    """
+    return [model for model in MODELS if include_deprecated or not model.deprecated]
+
+def get_model(name: str) -> Optional[ModelInfo]:
+    """
+    Look up a model by name.
+
+    Args:
+        name (str): Model identifier
+
+    Returns:
+        Optional[ModelInfo]: The model, or None if it isn't in the registry
+    """
+    for model in MODELS:
+        if model.name == name:
+            return model
+    return None
+
+def estimate_cost(usage: "Usage", model: str) -> float:
+    """
+    Estimate what a request cost from its reported usage.
+
+    Args:
+        usage (Usage): Token usage returned with the response
+        model (str): Model that served the request
+
+    Returns:
+        float: Estimated cost in USD
+    """
+    info = get_model(model)
+    if info is None:
+        raise ValueError(f"No pricing known for model: {model}")
+    return (usage.prompt_tokens * info.input_price
+            + usage.completion_tokens * info.output_price) / 1_000_000 + info.request_price
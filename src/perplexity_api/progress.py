@@ -0,0 +1,61 @@
+import sys
+import threading
+import time
+from typing import Optional, TextIO
+
+# Frames drawn in turn; plain ASCII so any terminal can show them
+_FRAMES = "|/-\\"
+
+# Seconds between redraws
+_INTERVAL = 0.1
+
+class Spinner:
+    """
+    Context manager that shows a spinner and the elapsed time while a call runs.
+
+    The line is drawn on stderr from a background thread and erased on exit, so
+    it never mixes with the answer on stdout.
+
+    Example:
+        with Spinner(enabled=sys.stdout.isatty()):
+            response = client.query("...")
+    """
+    def __init__(self, message: str = "Waiting for answer", enabled: bool = True,
+                 stream: Optional[TextIO] = None):
+        """
+        Initialize the spinner.
+
+        Args:
+            message (str): Text shown next to the spinner
+            enabled (bool): Show nothing when False; drawing is also skipped
+                            when the stream isn't a terminal
+            stream (Optional[TextIO]): Where to draw; defaults to sys.stderr
+        """
+        self.message = message
+        self.stream = stream or sys.stderr
+        self.enabled = enabled and self.stream.isatty()
+        self._stop = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    def __enter__(self) -> "Spinner":
+        if self.enabled:
+            self._thread = threading.Thread(target=self._run, daemon=True)
+            self._thread.start()
+        return self
+
+    def __exit__(self, *exc_info) -> None:
+        if self._thread is None:
+            return
+        self._stop.set()
+        self._thread.join()
+        self.stream.write("\r\033[K")
+        self.stream.flush()
+
+    def _run(self) -> None:
+        started = time.monotonic()
+        frame = 0
+        while not self._stop.wait(_INTERVAL):
+            elapsed = time.monotonic() - started
+            self.stream.write(f"\r{_FRAMES[frame % len(_FRAMES)]} {self.message}... {elapsed:.1f}s")
+            self.stream.flush()
+            frame += 1
@@ -0,0 +1,146 @@
+import threading
+import time
+from typing import Optional
+
+from .exceptions import RequestCancelled
+
+class RateLimiter:
+    """
+    Thread-safe token bucket limiting how often requests may be sent.
+
+    Attributes:
+        rate (float): Tokens added per second
+        burst (int): Maximum number of tokens that can accumulate
+    """
+    def __init__(self, rate: float, burst: int = 1):
+        """
+        Initialize the limiter with a full bucket.
+
+        Args:
+            rate (float): Requests allowed per second
+            burst (int): Requests that may be sent back-to-back before throttling
+        """
+        if rate <= 0:
+            raise ValueError("rate must be positive")
+        if burst < 1:
+            raise ValueError("burst must be at least 1")
+        self.rate = rate
+        self.burst = burst
+        self._tokens = float(burst)
+        self._last = time.monotonic()
+        self._lock = threading.Lock()
+
+    def _reserve(self) -> float:
+        """Take a token and return how long the caller must wait before using it."""
+        with self._lock:
+            now = time.monotonic()
+            self._tokens = min(self.burst, self._tokens + (now - self._last) * self.rate)
+            self._last = now
+            self._tokens -= 1
+            if self._tokens >= 0:
+                return 0.0
+            return -self._tokens / self.rate
+
+    def _release(self) -> None:
+        """Hand back a reserved token that was never used."""
+        with self._lock:
+            self._tokens = min(self.burst, self._tokens + 1)
+
+    def on_rate_limited(self) -> None:
+        """Note that the API answered 429; a fixed limiter ignores this."""
+
+    def on_success(self) -> None:
+        """Note that a request succeeded; a fixed limiter ignores this."""
+
+    def on_quota(self, remaining: int, reset_in: float) -> None:
+        """Note the quota the API reported; a fixed limiter ignores this."""
+
+    def wait(self, cancel: Optional[threading.Event] = None,
+             deadline: Optional[float] = None) -> None:
+        """
+        Block until a request may be sent.
+
+        Args:
+            cancel (Optional[threading.Event]): Stops waiting with RequestCancelled when set
+            deadline (Optional[float]): time.monotonic() value the caller must be done by
+
+        Raises:
+            TimeoutError: If no request may be sent before the deadline; raised
+                          straight away rather than after waiting
+        """
+        delay = self._reserve()
+        if delay <= 0:
+            return
+        if deadline is not None and time.monotonic() + delay > deadline:
+            self._release()
+            raise TimeoutError("Rate limit would delay the request past its deadline")
+        if cancel is None:
+            time.sleep(delay)
+        elif cancel.wait(delay):
+            self._release()
+            raise RequestCancelled("Request cancelled")
+
+class AdaptiveRateLimiter(RateLimiter):
+    """
+    Rate limiter that finds the allowed rate by itself (additive increase,
+    multiplicative decrease).
+
+    Each 429 cuts the rate by decrease_factor; every recovery_period without one,
+    a successful request raises it by increase, up to max_rate. When the API reports
+    its remaining quota, the rate is also capped so the quota lasts until it resets.
+
+    Attributes:
+        min_rate (float): Lowest rate the limiter backs off to
+        max_rate (float): Highest rate it recovers to
+    """
+    def __init__(self, rate: float, min_rate: float, max_rate: Optional[float] = None,
+                 burst: int = 1, increase: Optional[float] = None,
+                 decrease_factor: float = 0.5, recovery_period: float = 10.0):
+        """
+        Initialize the limiter at its starting rate.
+
+        Args:
+            rate (float): Requests per second to start at
+            min_rate (float): Lower bound for the rate
+            max_rate (Optional[float]): Upper bound for the rate; defaults to rate
+            burst (int): Requests that may be sent back-to-back before throttling
+            increase (Optional[float]): Requests per second added on each recovery
+                                        step; defaults to a tenth of max_rate
+            decrease_factor (float): Multiplier applied to the rate on each 429
+            recovery_period (float): Seconds without a 429 between increases
+        """
+        super().__init__(rate, burst)
+        max_rate = rate if max_rate is None else max_rate
+        if not 0 < min_rate <= max_rate:
+            raise ValueError("rates must satisfy 0 < min_rate <= max_rate")
+        if not 0 < decrease_factor < 1:
+            raise ValueError("decrease_factor must be between 0 and 1")
+        self.min_rate = min_rate
+        self.max_rate = max_rate
+        self.rate = min(max(rate, min_rate), max_rate)
+        self._increase = increase if increase is not None else max_rate / 10
+        self._decrease_factor = decrease_factor
+        self._recovery_period = recovery_period
+        self._calm_since = time.monotonic()
+
+    def on_rate_limited(self) -> None:
+        with self._lock:
+            self.rate = max(self.min_rate, self.rate * self._decrease_factor)
+            self._calm_since = time.monotonic()
+            # Drop any saved-up burst so the slower rate applies from the next request
+            self._tokens = min(self._tokens, 0)
+            self._last = self._calm_since
+
+    def on_quota(self, remaining: int, reset_in: float) -> None:
+        # Spread what is left of the window over the time until it resets
+        if reset_in <= 0:
+            return
+        with self._lock:
+            self.rate = min(self.rate, max(self.min_rate, remaining / reset_in))
+
+    def on_success(self) -> None:
+        with self._lock:
+            now = time.monotonic()
+            if self.rate < self.max_rate and now - self._calm_since >= self._recovery_period:
+                self.rate = min(self.max_rate, self.rate + self._increase)
+                self._calm_since = now
@@ -0,0 +1,22 @@
+import unicodedata
+
+# Control characters that are meaningful in prompts and must be kept
+_ALLOWED_CONTROL = {"\n", "\r", "\t"}
+
+def sanitize_input(text: str) -> str:
+    """
+    Remove non-printable control characters from user input.
+
+    Punctuation, symbols, accented letters and emoji are preserved, so prompts
+    such as "What's the C++ syntax for a for-loop?" pass through unchanged.
+
+    Args:
+        text (str): Raw user input
+
+    Returns:
+        str: The input without control characters other than newlines and tabs
+    """
+    return "".join(
+        char for char in text
+        if char in _ALLOWED_CONTROL or unicodedata.category(char) != "Cc"
+    )
@@ -0,0 +1,95 @@
+import copy
+import threading
+import time
+from typing import Any, Callable, Dict, Optional, Tuple
+
+from .exceptions import RequestCancelled
+
+# Seconds between a waiting caller's checks of its own cancel event and deadline
+_POLL_INTERVAL = 0.05
+
+class _Call:
+    """One in-flight call and, once it finishes, its outcome."""
+    def __init__(self):
+        self.done = threading.Event()
+        self.result: Any = None
+        self.error: Optional[BaseException] = None
+
+class SingleFlight:
+    """
+    Thread-safe deduplication of identical concurrent calls.
+
+    While a call for a key is running, other callers asking for the same key
+    wait for it and share its result (or its exception) instead of starting
+    their own. Each waiting caller still honours its own cancel event and
+    deadline, and gets its own copy of the result.
+    """
+    def __init__(self):
+        self._calls: Dict[str, _Call] = {}
+        self._lock = threading.Lock()
+
+    def do(self, key: str, fn: Callable[[], Any],
+           cancel: Optional[threading.Event] = None,
+           deadline: Optional[float] = None,
+           private_errors: Tuple[type, ...] = ()) -> Any:
+        """
+        Run fn, unless a call for key is already running, and return its result.
+
+        Args:
+            key (str): Identifies calls that are interchangeable
+            fn (Callable[[], Any]): Produces the result
+            cancel (Optional[threading.Event]): Stops this caller waiting on another's
+                                                call with RequestCancelled when set
+            deadline (Optional[float]): time.monotonic() value after which this caller
+                                        stops waiting on another's call with TimeoutError
+            private_errors (Tuple[type, ...]): Exceptions that concern only the caller
+                                               that raised them, such as its own
+                                               cancellation or timeout; a waiting
+                                               caller runs the call again instead
+
+        Returns:
+            Any: What fn returned; callers that joined another's call get a deep copy
+
+        Raises:
+            RequestCancelled: If cancel is set while waiting
+            TimeoutError: If the deadline passes while waiting
+            BaseException: Whatever fn raised, re-raised in every waiting caller
+                           unless it is one of private_errors
+        """
+        while True:
+            with self._lock:
+                call = self._calls.get(key)
+                leader = call is None
+                if leader:
+                    call = self._calls[key] = _Call()
+            if leader:
+                break
+            _wait(call, cancel, deadline)
+            if call.error is None:
+                return copy.deepcopy(call.result)
+            if not isinstance(call.error, private_errors):
+                raise call.error
+            # The other caller gave up for its own reasons; try again, perhaps leading
+        try:
+            call.result = fn()
+            return call.result
+        except BaseException as e:
+            call.error = e
+            raise
+        finally:
+            with self._lock:
+                del self._calls[key]
+            call.done.set()
+
+def _wait(call: _Call, cancel: Optional[threading.Event], deadline: Optional[float]) -> None:
+    """Block until call finishes, checking the waiter's own cancel event and deadline."""
+    while not call.done.is_set():
+        if cancel is not None and cancel.is_set():
+            raise RequestCancelled("Request cancelled")
+        interval = _POLL_INTERVAL
+        if deadline is not None:
+            remaining = deadline - time.monotonic()
+            if remaining <= 0:
+                raise TimeoutError("Timed out waiting for an identical request in flight")
+            interval = min(interval, remaining)
+        call.done.wait(interval)
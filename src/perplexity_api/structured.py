@@ -0,0 +1,76 @@
+import dataclasses
+import json
+import typing
+from typing import Any, Dict, Union
+
+# JSON Schema types for the Python types schema_from_dataclass understands
+_JSON_TYPES = {str: "string", int: "integer", float: "number", bool: "boolean"}
+
+def json_schema_format(schema: Union[Dict[str, Any], str, type]) -> Dict[str, Any]:
+    """
+    Build a response_format that asks for output matching a JSON Schema.
+
+    Args:
+        schema (Union[Dict[str, Any], str, type]): The schema as a dict, as raw JSON
+                                                  text, or a dataclass to derive it from
+
+    Returns:
+        Dict[str, Any]: Value for the response_format request parameter
+
+    Raises:
+        ValueError: If the schema is invalid JSON or not a JSON object
+    """
+    if isinstance(schema, type):
+        schema = schema_from_dataclass(schema)
+    elif isinstance(schema, str):
+        try:
+            schema = json.loads(schema)
+        except json.JSONDecodeError as e:
+            raise ValueError(f"Invalid JSON schema: {e}")
+    if not isinstance(schema, dict):
+        raise ValueError("JSON schema must be an object")
+    return {"type": "json_schema", "json_schema": {"schema": schema}}
+
+def schema_from_dataclass(cls: type) -> Dict[str, Any]:
+    """
+    Derive a JSON Schema from a dataclass's field types.
+
+    str, int, float, bool, lists, Optional and nested dataclasses are supported;
+    fields without a default are required.
+
+    Args:
+        cls (type): Dataclass describing the expected output
+
+    Returns:
+        Dict[str, Any]: JSON Schema for an object with the dataclass's fields
+    """
+    if not dataclasses.is_dataclass(cls):
+        raise TypeError(f"{cls!r} is not a dataclass")
+    hints = typing.get_type_hints(cls)
+    properties = {}
+    required = []
+    for field in dataclasses.fields(cls):
+        properties[field.name] = _schema_for(hints[field.name])
+        if field.default is dataclasses.MISSING and field.default_factory is dataclasses.MISSING:
+            required.append(field.name)
+    return {"type": "object", "properties": properties, "required": required}
+
+def _schema_for(annotation: Any) -> Dict[str, Any]:
+    """Map one type annotation to a JSON Schema fragment."""
+    if annotation in _JSON_TYPES:
+        return {"type": _JSON_TYPES[annotation]}
+    if dataclasses.is_dataclass(annotation):
+        return schema_from_dataclass(annotation)
+    # typing.get_origin/get_args need Python 3.8
+    origin = getattr(annotation, "__origin__", None)
+    args = getattr(annotation, "__args__", ())
+    if origin in (list, tuple, set, frozenset):
+        return {"type": "array", "items": _schema_for(args[0]) if args else {}}
+    if origin is Union:
+        options = [arg for arg in args if arg is not type(None)]
+        if len(options) == 1:
+            return _schema_for(options[0])
+        return {"anyOf": [_schema_for(option) for option in options]}
+    if origin is dict or annotation is dict:
+        return {"type": "object"}
+    raise TypeError(f"No JSON Schema mapping for type {annotation!r}")
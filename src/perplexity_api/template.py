@@ -0,0 +1,46 @@
+from string import Template
+from typing import Dict, List
+
+def render_prompt(template: str, variables: Dict[str, str]) -> str:
+    """
+    Fill the $name or ${name} placeholders in a prompt template.
+
+    Write $$ for a literal dollar sign.
+
+    Args:
+        template (str): Prompt text, e.g. "Summarize $topic in $n words"
+        variables (Dict[str, str]): Values for the placeholders
+
+    Returns:
+        str: The rendered prompt
+
+    Raises:
+        ValueError: If a placeholder has no value or the template is malformed
+    """
+    try:
+        return Template(template).substitute(variables)
+    except KeyError as e:
+        raise ValueError(f"No value given for template variable {e.args[0]!r}") from None
+    except ValueError as e:
+        raise ValueError(f"Invalid prompt template: {e}") from None
+
+def parse_vars(assignments: List[str]) -> Dict[str, str]:
+    """
+    Parse KEY=VALUE strings into a dict of template variables.
+
+    Args:
+        assignments (List[str]): Strings such as "topic=black holes"
+
+    Returns:
+        Dict[str, str]: Variable names mapped to their values
+
+    Raises:
+        ValueError: If an assignment has no "=" or an empty name
+    """
+    variables = {}
+    for assignment in assignments:
+        name, sep, value = assignment.partition("=")
+        if not sep or not name:
+            raise ValueError(f"Template variables must look like KEY=VALUE, got {assignment!r}")
+        variables[name] = value
+    return variables
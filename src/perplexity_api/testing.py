@@ -0,0 +1,141 @@
+"""
+Helpers for testing code that uses PerplexityAPI without calling the real API.
+
+Example, checking that a 429 is retried:
+
+    from perplexity_api.testing import make_response, make_test_client
+
+    replies = [make_response(429, headers={"Retry-After": "0"}),
+               make_response(json={"choices": [{"message": {"content": "hi"}}]})]
+    client, stub = make_test_client(lambda request: replies.pop(0))
+    assert client.query("hello")["choices"][0]["message"]["content"] == "hi"
+    assert len(stub.requests) == 2
+    assert stub.payloads()[0]["messages"][-1]["content"] == "hello"
+"""
+import io
+import json
+from http import HTTPStatus
+from typing import Any, Callable, Dict, Iterable, List, Optional, Tuple
+
+import requests
+from requests.adapters import BaseAdapter
+from requests.structures import CaseInsensitiveDict
+
+from .client import PerplexityAPI
+from .models import MODELS
+
+# Endpoint test clients are pointed at; never resolved because the adapter answers first
+TEST_BASE_URL = "https://perplexity.test/chat/completions"
+
+def make_response(status: int = 200, json: Optional[Any] = None, body: bytes = b"",
+                  headers: Optional[Dict[str, str]] = None) -> requests.Response:
+    """
+    Build a response for a stub handler to return.
+
+    Args:
+        status (int): HTTP status code
+        json (Optional[Any]): Body to send as JSON; takes precedence over body
+        body (bytes): Raw body, for non-JSON responses
+        headers (Optional[Dict[str, str]]): Response headers
+
+    Returns:
+        requests.Response: The response, not yet tied to a request
+    """
+    response = requests.Response()
+    response.status_code = status
+    try:
+        response.reason = HTTPStatus(status).phrase
+    except ValueError:
+        response.reason = ""
+    response.headers = CaseInsensitiveDict(headers or {})
+    if json is not None:
+        body = _json_dumps(json).encode("utf-8")
+        response.headers.setdefault("Content-Type", "application/json")
+    response.raw = io.BytesIO(body)
+    response.encoding = "utf-8"
+    return response
+
+def make_stream_response(chunks: Iterable[Dict], done: bool = True,
+                         headers: Optional[Dict[str, str]] = None) -> requests.Response:
+    """
+    Build a server-sent events response from stream chunks.
+
+    Args:
+        chunks (Iterable[Dict]): Chunks to send, each as one "data:" event
+        done (bool): End the stream with "data: [DONE]"
+        headers (Optional[Dict[str, str]]): Response headers
+
+    Returns:
+        requests.Response: The streaming response
+    """
+    events = [f"data: {_json_dumps(chunk)}\n\n" for chunk in chunks]
+    if done:
+        events.append("data: [DONE]\n\n")
+    headers = dict(headers or {})
+    headers.setdefault("Content-Type", "text/event-stream")
+    return make_response(body="".join(events).encode("utf-8"), headers=headers)
+
+class StubAdapter(BaseAdapter):
+    """
+    Transport adapter that answers requests from a handler instead of the network.
+
+    Attributes:
+        requests (List[requests.PreparedRequest]): Every request received, in order
+    """
+    def __init__(self, handler: Callable[[requests.PreparedRequest], requests.Response]):
+        """
+        Initialize the adapter.
+
+        Args:
+            handler (Callable[[requests.PreparedRequest], requests.Response]): Returns the
+                response for each request, e.g. from make_response; may raise
+                requests.exceptions.ConnectionError to simulate a network failure
+        """
+        super().__init__()
+        self.handler = handler
+        self.requests: List[requests.PreparedRequest] = []
+
+    def send(self, request: requests.PreparedRequest, **kwargs) -> requests.Response:
+        self.requests.append(request)
+        response = self.handler(request)
+        response.request = request
+        response.url = request.url
+        return response
+
+    def close(self) -> None:
+        pass
+
+    def payloads(self) -> List[Dict]:
+        """Return the JSON body of every request received."""
+        return [json.loads(request.body) for request in self.requests]
+
+def make_test_client(handler: Callable[[requests.PreparedRequest], requests.Response],
+                     **kwargs) -> Tuple[PerplexityAPI, StubAdapter]:
+    """
+    Create a client whose requests are answered by a handler.
+
+    Retries happen without delay and the rate limits are effectively off, so tests
+    run quickly; pass keyword arguments to change these or any other setting.
+
+    Args:
+        handler (Callable[[requests.PreparedRequest], requests.Response]): Answers
+            each request
+        **kwargs: Passed on to PerplexityAPI
+
+    Returns:
+        Tuple[PerplexityAPI, StubAdapter]: The client and the adapter recording its requests
+    """
+    adapter = StubAdapter(handler)
+    session = requests.Session()
+    session.mount("https://", adapter)
+    session.mount("http://", adapter)
+    kwargs.setdefault("api_key", "test-key")
+    kwargs.setdefault("base_url", TEST_BASE_URL)
+    kwargs.setdefault("rate_limit", 1000.0)
+    kwargs.setdefault("model_rate_limits", {model.name: 1000.0 for model in MODELS})
+    kwargs.setdefault("retry_base_delay", 0)
+    return PerplexityAPI(session=session, **kwargs), adapter
+
+def _json_dumps(data: Any) -> str:
+    """Serialise a body; kept apart because make_response shadows the json module."""
+    return json.dumps(data)
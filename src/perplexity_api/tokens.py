@@ -0,0 +1,91 @@
+from typing import Dict, List
+
+from .content import content_text
+
+# Rough average for English text with the Llama tokenizer
+CHARS_PER_TOKEN = 4
+
+# Role markers and separators added around each message by the chat template
+TOKENS_PER_MESSAGE = 4
+
+# Rough allowance for one attached image, whatever its size
+TOKENS_PER_IMAGE = 1000
+
+def estimate_tokens(messages: List[Dict[str, str]]) -> int:
+    """
+    Approximate how many tokens a list of messages will use.
+
+    This is a chars/4 heuristic, typically within about 15% of the real count
+    for English prose; code and non-Latin scripts tokenize less efficiently.
+    Each attached image counts as TOKENS_PER_IMAGE.
+
+    Args:
+        messages (List[Dict[str, str]]): Messages as role/content dicts
+
+    Returns:
+        int: Estimated prompt token count
+    """
+    total = 0
+    for message in messages:
+        content = message.get("content") or ""
+        if isinstance(content, list):
+            total += TOKENS_PER_IMAGE * sum(1 for part in content
+                                            if isinstance(part, dict) and part.get("type") == "image_url")
+        text = content_text(content)
+        total += TOKENS_PER_MESSAGE + (len(text) + CHARS_PER_TOKEN - 1) // CHARS_PER_TOKEN
+    return total
+
+def split_text(text: str, max_tokens: int) -> List[str]:
+    """
+    Split text into pieces of at most max_tokens estimated tokens each.
+
+    Breaks fall between paragraphs where possible, then between lines, and only
+    mid-line for a single line that is too long on its own.
+
+    Args:
+        text (str): Text to split
+        max_tokens (int): Estimated token budget per piece
+
+    Returns:
+        List[str]: The pieces, in order; empty if the text is blank
+    """
+    if max_tokens < 1:
+        raise ValueError("max_tokens must be at least 1")
+    limit = max_tokens * CHARS_PER_TOKEN
+    pieces: List[str] = []
+    current = ""
+    for paragraph in text.split("\n\n"):
+        for part in _split_long(paragraph, limit):
+            candidate = f"{current}\n\n{part}" if current else part
+            if len(candidate) <= limit:
+                current = candidate
+                continue
+            if current:
+                pieces.append(current)
+            current = part
+    if current:
+        pieces.append(current)
+    return [piece for piece in pieces if piece.strip()]
+
+def _split_long(paragraph: str, limit: int) -> List[str]:
+    """Break a paragraph longer than limit characters at line ends, then anywhere."""
+    if len(paragraph) <= limit:
+        return [paragraph]
+    parts: List[str] = []
+    current = ""
+    for line in paragraph.split("\n"):
+        while len(line) > limit:
+            if current:
+                parts.append(current)
+                current = ""
+            parts.append(line[:limit])
+            line = line[limit:]
+        candidate = f"{current}\n{line}" if current else line
+        if len(candidate) <= limit:
+            current = candidate
+        else:
+            parts.append(current)
+            current = line
+    if current:
+        parts.append(current)
+    return parts
@@ -0,0 +1,96 @@
+import time
+from contextlib import contextmanager
+from typing import Any, Dict, Iterator, Optional
+
+try:
+    from opentelemetry import trace
+    from opentelemetry.trace import Status, StatusCode
+except ImportError:  # OpenTelemetry is optional; see the tracing extra in setup.py
+    trace = None
+
+# Instrumentation scope reported with every span
+TRACER_NAME = "perplexity_api"
+
+def default_tracer() -> Optional[Any]:
+    """Return the global OpenTelemetry tracer, or None if OpenTelemetry isn't installed."""
+    return trace.get_tracer(TRACER_NAME) if trace is not None else None
+
+class RequestSpan:
+    """
+    Span for one API call, or a stand-in that records nothing when tracing is off.
+
+    Attributes:
+        span: The OpenTelemetry span, or None
+    """
+    def __init__(self, span: Optional[Any]):
+        self.span = span
+        self._started = time.monotonic()
+
+    def set_attribute(self, name: str, value: Any) -> None:
+        if self.span is not None and value is not None:
+            self.span.set_attribute(name, value)
+
+    def set_usage(self, usage: Optional[Dict]) -> None:
+        """Record token usage from a response's usage field."""
+        if not usage:
+            return
+        self.set_attribute("gen_ai.usage.input_tokens", usage.get("prompt_tokens"))
+        self.set_attribute("gen_ai.usage.output_tokens", usage.get("completion_tokens"))
+
+    def fail(self, error: BaseException) -> None:
+        """Mark the call as failed, keeping the status code of an API error."""
+        if self.span is None:
+            return
+        self.set_attribute("http.response.status_code", getattr(error, "status_code", None))
+        self.span.record_exception(error)
+        if trace is not None:
+            self.span.set_status(Status(StatusCode.ERROR, str(error)))
+
+    def finish(self) -> None:
+        """Record the latency and end the span."""
+        if self.span is None:
+            return
+        self.span.set_attribute("perplexity.latency_ms",
+                                round((time.monotonic() - self._started) * 1000, 1))
+        self.span.end()
+
+@contextmanager
+def request_span(tracer: Optional[Any], name: str, model: str,
+                 current: bool = True) -> Iterator[RequestSpan]:
+    """
+    Trace one API call as a client span, a child of the caller's current span.
+
+    Args:
+        tracer (Optional[Any]): OpenTelemetry tracer; None disables tracing
+        name (str): Span name
+        model (str): Model the request is for
+        current (bool): Make the span current inside the block, so HTTP
+                        instrumentation nests under it; must be False in a
+                        generator, where the block spans yields to the caller
+
+    Yields:
+        RequestSpan: Receives the call's status code and usage; failures raised
+                     inside the block are recorded on the span and re-raised
+    """
+    span = None
+    if tracer is not None:
+        attributes = {"gen_ai.system": "perplexity", "gen_ai.request.model": model}
+        if trace is not None:
+            span = tracer.start_span(name, kind=trace.SpanKind.CLIENT, attributes=attributes)
+        else:
+            span = tracer.start_span(name, attributes=attributes)
+    request = RequestSpan(span)
+    try:
+        if span is not None and current and trace is not None:
+            with trace.use_span(span, end_on_exit=False):
+                yield request
+        else:
+            yield request
+    except GeneratorExit:
+        # A stream the caller stopped reading early isn't a failure
+        raise
+    except BaseException as e:
+        request.fail(e)
+        raise
+    finally:
+        request.finish()
@@ -0,0 +1,96 @@
+import gzip
+import json
+import unittest
+from unittest import mock
+
+from perplexity_api.testing import make_response, make_test_client
+
+REPLY = {"choices": [{"message": {"role": "assistant", "content": "hi"}}]}
+
+class GzipResponseTest(unittest.TestCase):
+    """
+    Some proxies gzip the body without sending Content-Encoding; the client
+    recognises the gzip magic bytes and decompresses the body itself.
+    """
+    def test_gzipped_body_without_content_encoding_is_parsed(self):
+        body = gzip.compress(json.dumps(REPLY).encode("utf-8"))
+        client, _ = make_test_client(lambda request: make_response(
+            body=body, headers={"Content-Type": "application/json"}))
+        response = client.query("hello")
+        self.assertEqual(response["choices"][0]["message"]["content"], "hi")
+
+    def test_plain_body_is_still_parsed(self):
+        client, _ = make_test_client(lambda request: make_response(json=REPLY))
+        self.assertEqual(client.query("hello"), REPLY)
+
+class BuildRequestTemperatureTest(unittest.TestCase):
+    """
+    temperature is sent whenever it is set, including 0 (deterministic output),
+    and left out entirely when the client's temperature is None so the API
+    default applies. A per-call None falls back to the client's setting.
+    """
+    def body(self, client_options=None, **params):
+        client, _ = make_test_client(lambda request: make_response(json=REPLY),
+                                     **(client_options or {}))
+        return client.build_request([{"role": "user", "content": "hello"}], **params)["body"]
+
+    def test_default_temperature_is_sent(self):
+        self.assertEqual(self.body()["temperature"], 0.2)
+
+    def test_zero_temperature_is_sent(self):
+        self.assertEqual(self.body(temperature=0)["temperature"], 0)
+
+    def test_none_temperature_is_left_out(self):
+        self.assertNotIn("temperature", self.body({"temperature": None}))
+
+    def test_per_call_zero_overrides_none(self):
+        self.assertEqual(self.body({"temperature": None}, temperature=0)["temperature"], 0)
+
+    def test_per_call_none_keeps_the_client_setting(self):
+        self.assertEqual(self.body({"temperature": 0.7}, temperature=None)["temperature"], 0.7)
+
+if __name__ == "__main__":
+    unittest.main()
+
+class ProxyTest(unittest.TestCase):
+    """
+    An explicit proxy is used even when HTTPS_PROXY is set; without one the
+    environment's proxy settings apply, as they would for requests.post.
+    """
+    def sent_proxies(self, **client_options):
+        client, adapter = make_test_client(lambda request: make_response(json=REPLY),
+                                           **client_options)
+        sent = []
+        send = adapter.send
+        def recording_send(request, **kwargs):
+            sent.append(kwargs.get("proxies"))
+            return send(request, **kwargs)
+        adapter.send = recording_send
+        client.query("hello")
+        return sent[0]
+
+    @mock.patch.dict("os.environ", {"HTTPS_PROXY": "http://env-proxy:3128"})
+    def test_explicit_proxy_beats_environment(self):
+        proxies = self.sent_proxies(proxy="http://explicit:3128")
+        self.assertEqual(proxies["https"], "http://explicit:3128")
+
+    @mock.patch.dict("os.environ", {"HTTPS_PROXY": "http://env-proxy:3128"})
+    def test_environment_proxy_used_without_explicit_proxy(self):
+        self.assertEqual(self.sent_proxies()["https"], "http://env-proxy:3128")
+
+class ModelRateLimiterBurstTest(unittest.TestCase):
+    """
+    Registry limits are per-minute quotas, so a minute's worth of requests may
+    go out back to back; model_rate_limits overrides are per-second rates with
+    no burst, however high the rate.
+    """
+    def limiters(self, **client_options):
+        client, _ = make_test_client(lambda request: make_response(json=REPLY),
+                                     **client_options)
+        return client.model_rate_limiters
+
+    def test_registry_limit_allows_a_minute_of_requests(self):
+        self.assertEqual(self.limiters(model_rate_limits=None)["sonar"].burst, 50)
+
+    def test_override_has_no_burst(self):
+        self.assertEqual(self.limiters(model_rate_limits={"sonar": 20})["sonar"].burst, 1)
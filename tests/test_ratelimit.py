@@ -0,0 +1,55 @@
+import threading
+import time
+import unittest
+
+from perplexity_api.exceptions import RequestCancelled
+from perplexity_api.ratelimit import AdaptiveRateLimiter, RateLimiter
+
+class RateLimiterWaitTest(unittest.TestCase):
+    """
+    wait() blocks until the bucket has a token, but gives up early when the
+    caller cancels or when the wait would run past the caller's deadline.
+    """
+    def drained(self, rate: float = 0.5) -> RateLimiter:
+        """Return a limiter whose only token has been used, so the next wait blocks."""
+        limiter = RateLimiter(rate)
+        limiter.wait()
+        return limiter
+
+    def test_cancel_while_waiting_raises_promptly(self):
+        limiter = self.drained()  # The next token is two seconds away
+        cancel = threading.Event()
+        threading.Timer(0.05, cancel.set).start()
+        start = time.monotonic()
+        with self.assertRaises(RequestCancelled):
+            limiter.wait(cancel=cancel)
+        self.assertLess(time.monotonic() - start, 0.5)
+
+    def test_deadline_too_short_raises_timeout_without_waiting(self):
+        limiter = self.drained()
+        start = time.monotonic()
+        with self.assertRaises(TimeoutError):
+            limiter.wait(deadline=time.monotonic() + 0.1)
+        self.assertLess(time.monotonic() - start, 0.05)
+
+    def test_deadline_with_room_waits_for_the_token(self):
+        limiter = self.drained(rate=20)
+        limiter.wait(deadline=time.monotonic() + 1)
+
+class AdaptiveRateLimiterTest(unittest.TestCase):
+    """
+    A 429 slows the limiter down at once: tokens saved up for a burst are
+    dropped, so the next request waits for the reduced rate.
+    """
+    def test_wait_blocks_right_after_rate_limited(self):
+        limiter = AdaptiveRateLimiter(10, min_rate=0.5, burst=50)
+        limiter.on_rate_limited()
+        with self.assertRaises(TimeoutError):
+            limiter.wait(deadline=time.monotonic() + 0.1)
+
+    def test_burst_is_available_before_rate_limited(self):
+        limiter = AdaptiveRateLimiter(10, min_rate=0.5, burst=50)
+        limiter.wait(deadline=time.monotonic() + 0.1)
+
+if __name__ == "__main__":
+    unittest.main()
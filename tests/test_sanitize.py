@@ -0,0 +1,95 @@
+import unittest
+
+from perplexity_api.sanitize import sanitize_input
+from perplexity_api.testing import make_response, make_test_client
+
+# A prompt full of characters an over-eager filter might strip: fences, braces,
+# backslashes, quotes, tabs, shell operators and regex syntax
+CODE_PROMPT = """Why does this fail?
+```python
+def parse(line: str) -> dict[str, int]:
+\tif not re.match(r"^\\s*(\\w+)=(\\d+)$", line):
+\t\traise ValueError(f"bad line: {line!r}")
+\treturn {k: int(v) for k, v in [line.split("=", 1)]}
+```
+```sh
+grep -E '^[a-z]+' *.txt | sort -u > out && echo "done: $?" 2>&1 || exit 1
+```
+`a <= b && c != d; x->y; ~mask ^ 0xFF; arr[i++] %= 3; #include <stdio.h>`
+"""
+
+class SanitizeInputTest(unittest.TestCase):
+    """
+    sanitize_input removes control characters (Unicode category Cc) and keeps
+    everything else. The only control characters allowed through are the ones
+    listed in ALLOWED below.
+    """
+    ALLOWED = "\n\r\t"
+
+    def test_empty_string(self):
+        self.assertEqual(sanitize_input(""), "")
+
+    def test_ascii_punctuation_is_kept(self):
+        text = "What's the C++ syntax for a for-loop? (e.g. `for (;;) {}`) #1 @home 50% & <tags>!"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_all_printable_ascii_is_kept(self):
+        text = "".join(chr(code) for code in range(0x20, 0x7F))
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_accented_letters_are_kept(self):
+        text = "Café, niño, Ångström, façade, Œuvre"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_decomposed_accents_are_kept(self):
+        # "e" followed by a combining acute accent is category Mn, not a control
+        text = "Cafe\u0301"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_non_latin_scripts_are_kept(self):
+        text = "日本語 русский العربية ελληνικά"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_emoji_are_kept(self):
+        text = "Deploy 🚀 done ✅ family 👨‍👩‍👧 flag 🇳🇿"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_allowed_whitespace_controls_are_kept(self):
+        text = "line one\nline two\r\n\tindented"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_allowlist_is_exactly_newline_carriage_return_and_tab(self):
+        controls = [chr(code) for code in range(0x00, 0x20)] + [chr(0x7F)]
+        kept = "".join(char for char in controls if sanitize_input(char))
+        self.assertEqual(kept, "\t\n\r")
+        self.assertEqual(sorted(kept), sorted(self.ALLOWED))
+
+    def test_c0_control_characters_are_removed(self):
+        self.assertEqual(sanitize_input("bell\x07 null\x00 escape\x1b[31m del\x7f"),
+                         "bell null escape[31m del")
+
+    def test_c1_control_characters_are_removed(self):
+        self.assertEqual(sanitize_input("next\x85line \x9bcsi"), "nextline csi")
+
+    def test_format_characters_are_kept(self):
+        # Zero-width spaces and joiners are category Cf, needed by emoji and some scripts
+        text = "a\u200bb\u200dc"
+        self.assertEqual(sanitize_input(text), text)
+
+    def test_only_control_characters_gives_empty_string(self):
+        self.assertEqual(sanitize_input("\x00\x01\x02\x1f"), "")
+
+class CodePromptTest(unittest.TestCase):
+    """Code in a prompt must reach the API exactly as written."""
+    def test_code_heavy_prompt_is_unchanged(self):
+        self.assertEqual(sanitize_input(CODE_PROMPT), CODE_PROMPT)
+
+    def test_code_heavy_prompt_is_sent_intact(self):
+        reply = {"choices": [{"message": {"role": "assistant", "content": "ok"}}]}
+        client, stub = make_test_client(lambda request: make_response(json=reply),
+                                        sanitizer=sanitize_input)
+        client.query(CODE_PROMPT)
+        self.assertEqual(stub.payloads()[0]["messages"][-1]["content"], CODE_PROMPT)
+
+if __name__ == "__main__":
+    unittest.main()
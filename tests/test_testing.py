@@ -0,0 +1,41 @@
+import unittest
+
+import requests
+
+from perplexity_api.exceptions import NetworkError, RateLimitError
+from perplexity_api.testing import make_response, make_test_client
+
+REPLY = {"choices": [{"message": {"role": "assistant", "content": "hi"}}]}
+
+class MakeTestClientTest(unittest.TestCase):
+    """
+    make_test_client answers requests from a handler, so retry behaviour can be
+    checked without the network. These double as examples of using it.
+    """
+    def test_429_is_retried(self):
+        replies = [make_response(429, headers={"Retry-After": "0"}), make_response(json=REPLY)]
+        client, stub = make_test_client(lambda request: replies.pop(0))
+        response = client.query("hello")
+        self.assertEqual(response["choices"][0]["message"]["content"], "hi")
+        self.assertEqual(len(stub.requests), 2)
+        self.assertEqual(stub.payloads()[0]["messages"][-1]["content"], "hello")
+
+    def test_429_after_all_retries_raises_rate_limit_error(self):
+        client, stub = make_test_client(
+            lambda request: make_response(429, headers={"Retry-After": "0"}), max_retries=2)
+        with self.assertRaises(RateLimitError) as caught:
+            client.query("hello")
+        self.assertEqual(caught.exception.retry_after, 0)
+        self.assertEqual(len(stub.requests), 3)
+
+    def test_connection_error_becomes_network_error(self):
+        def refuse(request):
+            raise requests.exceptions.ConnectionError("refused")
+
+        client, stub = make_test_client(refuse, max_retries=1)
+        with self.assertRaises(NetworkError):
+            client.query("hello")
+        self.assertEqual(len(stub.requests), 2)
+
+if __name__ == "__main__":
+    unittest.main()